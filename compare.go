@@ -1,8 +1,15 @@
 package testequals
 
 import (
+	"cmp"
+	"encoding"
 	"fmt"
+	"math"
 	"reflect"
+	"slices"
+	"strconv"
+
+	"github.com/calumari/jwalk"
 )
 
 var nilableKinds = map[reflect.Kind]struct{}{
@@ -49,27 +56,186 @@ func isEmpty(v reflect.Value) bool {
 	}
 }
 
+// asDocument views an actual value as a jwalk.Document so plain Go maps (e.g.
+// map[string]any from encoding/json) and structs get the same object semantics.
+// Maps keyed by strings or integers are accepted, integer keys being formatted
+// in base 10 as encoding/json does, and their entries are sorted by key so that
+// traversal and mismatch reporting are deterministic. Structs are walked
+// through their `json` tags without being serialized (see structFields);
+// structs with their own text encoding (e.g. time.Time) are not objects, and
+// values implementing json.Marshaler are viewed through their encoding (see
+// marshaledValue). Pointers to either are dereferenced.
+func asDocument(v any) (jwalk.Document, bool) {
+	switch d := v.(type) {
+	case jwalk.Document:
+		return d, true
+	case map[string]any:
+		doc := make(jwalk.Document, 0, len(d))
+		for k, val := range d {
+			doc = append(doc, jwalk.Entry{Key: k, Value: val})
+		}
+		sortEntries(doc)
+		return doc, true
+	}
+	rv := derefValue(reflect.ValueOf(v))
+	if decoded, ok := marshaledValue(rv); ok {
+		return asDocument(decoded)
+	}
+	if rv.Kind() == reflect.Struct {
+		if isTextMarshaler(rv.Type()) {
+			return nil, false
+		}
+		return structDocument(rv), true
+	}
+	if rv.Kind() != reflect.Map {
+		return nil, false
+	}
+	var key func(k reflect.Value) string
+	switch rv.Type().Key().Kind() {
+	case reflect.String:
+		key = reflect.Value.String
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		key = func(k reflect.Value) string { return strconv.FormatInt(k.Int(), 10) }
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		key = func(k reflect.Value) string { return strconv.FormatUint(k.Uint(), 10) }
+	default:
+		return nil, false
+	}
+	doc := make(jwalk.Document, 0, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		doc = append(doc, jwalk.Entry{Key: key(iter.Key()), Value: jsonValue(iter.Value())})
+	}
+	sortEntries(doc)
+	return doc, true
+}
+
+func sortEntries(doc jwalk.Document) {
+	slices.SortFunc(doc, func(a, b jwalk.Entry) int { return cmp.Compare(a.Key, b.Key) })
+}
+
+// asArray views an actual value as a jwalk.Array so plain Go slices and arrays
+// (e.g. []any from encoding/json, or []string) get the same array semantics.
+// Values implementing json.Marshaler are viewed through their encoding.
+func asArray(v any) (jwalk.Array, bool) {
+	switch a := v.(type) {
+	case jwalk.Array:
+		return a, true
+	case []any:
+		return jwalk.Array(a), true
+	}
+	rv := derefValue(reflect.ValueOf(v))
+	if decoded, ok := marshaledValue(rv); ok {
+		return asArray(decoded)
+	}
+	if !isList(rv) {
+		return nil, false
+	}
+	arr := make(jwalk.Array, rv.Len())
+	for i := range arr {
+		arr[i] = jsonValue(rv.Index(i))
+	}
+	return arr, true
+}
+
+// jsonTypes lists the type names understood by "$type".
+var jsonTypes = []string{"string", "number", "integer", "boolean", "object", "array", "null"}
+
+// jsonTypeOf classifies v by the JSON type it represents, using the same views
+// as the comparison itself: Go numbers are numbers, text marshalers strings and
+// anything asDocument or asArray accepts an object or array, while nil slices
+// and maps are null (see plainValue). Numbers with an integral value are
+// reported as "integer", which "number" also matches.
+func jsonTypeOf(v any) string {
+	v = plainValue(reflect.ValueOf(v))
+	switch n := v.(type) {
+	case nil:
+		return "null"
+	case string, []byte, encoding.TextMarshaler:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "integer"
+	case float32, float64:
+		f, _ := toFloat64(n)
+		if f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	if _, ok := asDocument(v); ok {
+		return "object"
+	}
+	if _, ok := asArray(v); ok {
+		return "array"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// derefValue follows non-nil pointers to the value they point at.
+func derefValue(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer && !v.IsNil() {
+		v = v.Elem()
+	}
+	return v
+}
+
+// leafValue normalizes an actual value compared against a primitive expected
+// value: named scalar types and pointers are unwrapped via plainValue, and
+// values with their own text encoding are compared through it when a string
+// is expected.
+func leafValue(expected, actual any) any {
+	switch actual.(type) {
+	case nil, string, bool, float64, int, int64:
+		return actual
+	}
+	if tm, ok := actual.(encoding.TextMarshaler); ok {
+		if _, isString := expected.(string); isString {
+			if text, err := tm.MarshalText(); err == nil {
+				return string(text)
+			}
+		}
+		return actual
+	}
+	return plainValue(reflect.ValueOf(actual))
+}
+
+// asText views v as a string for the string rules: strings, including named
+// string types, and the text form of encoding.TextMarshaler values.
+func asText(v any) (string, bool) {
+	switch s := plainValue(reflect.ValueOf(v)).(type) {
+	case string:
+		return s, true
+	case encoding.TextMarshaler:
+		text, err := s.MarshalText()
+		return string(text), err == nil
+	}
+	return "", false
+}
+
 // fastPrimitiveEqual attempts a high-performance comparison for primitive
 // scalar types. It returns (handled, err). When handled is true, either the
-// values were equal (err == nil) or a mismatch is described by err.
+// values were equal (err == nil) or a mismatch is described by err, which is
+// always a path-less *MismatchError of kind TypeMismatch or ValueMismatch.
 func fastPrimitiveEqual(a, b any) (handled bool, err error) {
 	switch av := a.(type) {
 	case string:
 		bv, ok := b.(string)
 		if !ok {
-			return true, fmt.Errorf("expected string %q, got %T", av, b)
+			return true, newMismatch(TypeMismatch, a, b, fmt.Sprintf("expected string %q, got %T", av, b))
 		}
 		if av != bv {
-			return true, fmt.Errorf("expected string %q, got %q", av, bv)
+			return true, newMismatch(ValueMismatch, a, b, fmt.Sprintf("expected string %q, got %q", av, bv))
 		}
 		return true, nil
 	case bool:
 		bv, ok := b.(bool)
 		if !ok {
-			return true, fmt.Errorf("expected bool %v, got %T", av, b)
+			return true, newMismatch(TypeMismatch, a, b, fmt.Sprintf("expected bool %v, got %T", av, b))
 		}
 		if av != bv {
-			return true, fmt.Errorf("expected bool %v, got %v", av, bv)
+			return true, newMismatch(ValueMismatch, a, b, fmt.Sprintf("expected bool %v, got %v", av, bv))
 		}
 		return true, nil
 	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
@@ -78,17 +244,17 @@ func fastPrimitiveEqual(a, b any) (handled bool, err error) {
 		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 			bi := toInt64(bv)
 			if ai != bi {
-				return true, fmt.Errorf("expected int %d, got %d", ai, bi)
+				return true, newMismatch(ValueMismatch, a, b, fmt.Sprintf("expected int %d, got %d", ai, bi))
 			}
 			return true, nil
 		case float32, float64:
 			bf, _ := toFloat64(bv)
 			if float64(ai) != bf {
-				return true, fmt.Errorf("expected int %d, got float %v", ai, bf)
+				return true, newMismatch(ValueMismatch, a, b, fmt.Sprintf("expected int %d, got float %v", ai, bf))
 			}
 			return true, nil
 		default:
-			return true, fmt.Errorf("expected integer (%d), got %T", ai, b)
+			return true, newMismatch(TypeMismatch, a, b, fmt.Sprintf("expected integer (%d), got %T", ai, b))
 		}
 	case float32, float64:
 		af, _ := toFloat64(av)
@@ -96,18 +262,197 @@ func fastPrimitiveEqual(a, b any) (handled bool, err error) {
 		case float32, float64:
 			bf, _ := toFloat64(bv)
 			if af != bf {
-				return true, fmt.Errorf("expected float %v, got %v", af, bf)
+				return true, newMismatch(ValueMismatch, a, b, fmt.Sprintf("expected float %v, got %v", af, bf))
 			}
 			return true, nil
 		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
 			bi := toInt64(bv)
 			if af != float64(bi) {
-				return true, fmt.Errorf("expected float %v, got int %d", af, bi)
+				return true, newMismatch(ValueMismatch, a, b, fmt.Sprintf("expected float %v, got int %d", af, bi))
 			}
 			return true, nil
 		default:
-			return true, fmt.Errorf("expected float (%v), got %T", af, b)
+			return true, newMismatch(TypeMismatch, a, b, fmt.Sprintf("expected float (%v), got %T", af, b))
 		}
 	}
 	return false, nil
 }
+
+// tolerance bounds the difference allowed between two numbers: they are close
+// when it is at most abs or at most rel times the larger magnitude. The zero
+// tolerance only accepts equal numbers.
+type tolerance struct{ abs, rel float64 }
+
+func (t tolerance) close(a, b float64) bool {
+	d := math.Abs(a - b)
+	return d <= t.abs || d <= t.rel*math.Max(math.Abs(a), math.Abs(b))
+}
+
+func (t tolerance) String() string {
+	switch {
+	case t.rel == 0:
+		return fmt.Sprintf("abs %v", t.abs)
+	case t.abs == 0:
+		return fmt.Sprintf("rel %v", t.rel)
+	default:
+		return fmt.Sprintf("abs %v, rel %v", t.abs, t.rel)
+	}
+}
+
+// numericClose compares two numeric leaves within tol. handled is false when
+// either value is not a number.
+func numericClose(expected, actual any, tol tolerance) (handled bool, err error) {
+	if _, isBool := expected.(bool); isBool {
+		return false, nil
+	}
+	e, ok := toFloat64(expected)
+	if !ok {
+		return false, nil
+	}
+	a, ok := toFloat64(actual)
+	if !ok {
+		return false, nil
+	}
+	if !tol.close(e, a) {
+		return true, newMismatch(ValueMismatch, expected, actual, fmt.Sprintf("expected %v, got %v (delta %.6g exceeds %s)", trimFloat(e), trimFloat(a), math.Abs(a-e), tol))
+	}
+	return true, nil
+}
+
+// matchElements pairs each of ne expected elements with a distinct one of na
+// actual elements for which try succeeds, maximizing the number of pairs. It
+// returns, for each expected element, the index of its actual element or -1
+// when it is left unmatched.
+//
+// It is Kuhn's augmenting path algorithm for maximum bipartite matching: an
+// expected element first takes a free matching actual element and otherwise
+// tries to move the owner of a matching element elsewhere. Unlike a greedy
+// first-fit, this finds a complete pairing whenever one exists, even when
+// loose expectations (subset documents, $any) match several elements. Each
+// pair is tried at most once.
+func matchElements(ne, na int, try func(ei, ai int) error) []int {
+	results := make(map[int]bool)
+	matches := func(ei, ai int) bool {
+		k := ei*na + ai
+		ok, known := results[k]
+		if !known {
+			ok = try(ei, ai) == nil
+			results[k] = ok
+		}
+		return ok
+	}
+	owner := make([]int, na)
+	for ai := range owner {
+		owner[ai] = -1
+	}
+	seen := make([]bool, na)
+	var augment func(ei int) bool
+	augment = func(ei int) bool {
+		for ai := range na {
+			if owner[ai] < 0 && !seen[ai] && matches(ei, ai) {
+				seen[ai] = true
+				owner[ai] = ei
+				return true
+			}
+		}
+		for ai := range na {
+			if owner[ai] < 0 || seen[ai] || !matches(ei, ai) {
+				continue
+			}
+			seen[ai] = true
+			if augment(owner[ai]) {
+				owner[ai] = ei
+				return true
+			}
+		}
+		return false
+	}
+	for ei := range ne {
+		clear(seen)
+		augment(ei)
+	}
+	matched := make([]int, ne)
+	for ei := range matched {
+		matched[ei] = -1
+	}
+	for ai, ei := range owner {
+		if ei >= 0 {
+			matched[ei] = ai
+		}
+	}
+	return matched
+}
+
+// primitiveKey identifies a primitive value in a multiset so that keys are
+// equal exactly when fastPrimitiveEqual reports the values equal: numbers of
+// any Go type compare by value (int 1 == float64 1.0).
+type primitiveKey struct {
+	kind byte // 'z' nil, 's' string, 'b' bool, 'n' number
+	s    string
+	f    float64
+}
+
+// maxExactInt bounds the integer magnitudes keyed by float64 value: every
+// integer below it converts exactly, while larger ones may round onto it.
+const maxExactInt = 1 << 53
+
+// primitiveKeyOf returns the multiset key of v. It returns false for values
+// that are not primitives, and for integers too large to be keyed by their
+// float64 value without merging distinct integers.
+func primitiveKeyOf(v any) (primitiveKey, bool) {
+	switch x := v.(type) {
+	case nil:
+		return primitiveKey{kind: 'z'}, true
+	case string:
+		return primitiveKey{kind: 's', s: x}, true
+	case bool:
+		if x {
+			return primitiveKey{kind: 'b', f: 1}, true
+		}
+		return primitiveKey{kind: 'b'}, true
+	case float32, float64:
+		f, _ := toFloat64(x)
+		return primitiveKey{kind: 'n', f: f}, true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		f, _ := toFloat64(x)
+		if f >= maxExactInt || f <= -maxExactInt {
+			return primitiveKey{}, false
+		}
+		return primitiveKey{kind: 'n', f: f}, true
+	}
+	return primitiveKey{}, false
+}
+
+// matchPrimitives is a fast path for matchElements when every expected and
+// actual element is a primitive: elements are paired by multiset counting in
+// O(n) instead of comparing every pair. The result has the same form as
+// matchElements; it returns false when an element is not a primitive.
+func matchPrimitives(expected, actual []any) ([]int, bool) {
+	byKey := make(map[primitiveKey][]int, len(actual))
+	for ai, a := range actual {
+		k, ok := primitiveKeyOf(a)
+		if !ok {
+			return nil, false
+		}
+		byKey[k] = append(byKey[k], ai)
+	}
+	keys := make([]primitiveKey, len(expected))
+	for ei, e := range expected {
+		k, ok := primitiveKeyOf(e)
+		if !ok {
+			return nil, false
+		}
+		keys[ei] = k
+	}
+	matched := make([]int, len(expected))
+	for ei, k := range keys {
+		matched[ei] = -1
+		// NaN keys never equal themselves, so NaN matches nothing, as with
+		// fastPrimitiveEqual.
+		if idx := byKey[k]; len(idx) > 0 {
+			matched[ei] = idx[0]
+			byKey[k] = idx[1:]
+		}
+	}
+	return matched, true
+}
@@ -1,10 +1,14 @@
 package testequals
 
 import (
+	"errors"
+	"math"
 	"reflect"
 	"testing"
 
+	"github.com/calumari/jwalk"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_isNil(t *testing.T) {
@@ -83,6 +87,74 @@ func Test_isEmpty(t *testing.T) {
 	})
 }
 
+func Test_asDocument(t *testing.T) {
+	t.Run("document returns itself", func(t *testing.T) {
+		doc := jwalk.Document{{Key: "b", Value: 1}, {Key: "a", Value: 2}}
+		got, ok := asDocument(doc)
+		assert.True(t, ok)
+		assert.Equal(t, doc, got)
+	})
+
+	t.Run("map any returns sorted entries", func(t *testing.T) {
+		got, ok := asDocument(map[string]any{"b": 1, "a": "x"})
+		assert.True(t, ok)
+		assert.Equal(t, jwalk.Document{{Key: "a", Value: "x"}, {Key: "b", Value: 1}}, got)
+	})
+
+	t.Run("typed map returns entries", func(t *testing.T) {
+		got, ok := asDocument(map[string]int{"a": 1})
+		assert.True(t, ok)
+		assert.Equal(t, jwalk.Document{{Key: "a", Value: 1}}, got)
+	})
+
+	t.Run("integer keyed map returns formatted keys", func(t *testing.T) {
+		got, ok := asDocument(map[int]string{2: "b", 10: "a"})
+		assert.True(t, ok)
+		assert.Equal(t, jwalk.Document{{Key: "10", Value: "a"}, {Key: "2", Value: "b"}}, got)
+	})
+
+	t.Run("unsupported key kind returns false", func(t *testing.T) {
+		_, ok := asDocument(map[float64]int{1: 1})
+		assert.False(t, ok)
+	})
+
+	t.Run("non-map returns false", func(t *testing.T) {
+		_, ok := asDocument([]int{1})
+		assert.False(t, ok)
+	})
+}
+
+func Test_asArray(t *testing.T) {
+	t.Run("array returns itself", func(t *testing.T) {
+		got, ok := asArray(jwalk.Array{1})
+		assert.True(t, ok)
+		assert.Equal(t, jwalk.Array{1}, got)
+	})
+
+	t.Run("slice any returns elements", func(t *testing.T) {
+		got, ok := asArray([]any{1, "a"})
+		assert.True(t, ok)
+		assert.Equal(t, jwalk.Array{1, "a"}, got)
+	})
+
+	t.Run("typed slice returns elements", func(t *testing.T) {
+		got, ok := asArray([]string{"a", "b"})
+		assert.True(t, ok)
+		assert.Equal(t, jwalk.Array{"a", "b"}, got)
+	})
+
+	t.Run("go array returns elements", func(t *testing.T) {
+		got, ok := asArray([2]int{1, 2})
+		assert.True(t, ok)
+		assert.Equal(t, jwalk.Array{1, 2}, got)
+	})
+
+	t.Run("non-list returns false", func(t *testing.T) {
+		_, ok := asArray(map[string]any{})
+		assert.False(t, ok)
+	})
+}
+
 func Test_fastPrimitiveEqual(t *testing.T) {
 	// string tests
 	t.Run("string vs non-string returns error", func(t *testing.T) {
@@ -97,6 +169,20 @@ func Test_fastPrimitiveEqual(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("mismatch error carries kind and values", func(t *testing.T) {
+		_, err := fastPrimitiveEqual("a", 1)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, TypeMismatch, merr.Kind)
+			assert.Equal(t, "a", merr.Expected)
+			assert.Equal(t, 1, merr.Actual)
+		}
+		_, err = fastPrimitiveEqual(1, 2.5)
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, ValueMismatch, merr.Kind)
+		}
+	})
+
 	t.Run("equal strings returns no error", func(t *testing.T) {
 		handled, err := fastPrimitiveEqual("a", "a")
 		assert.True(t, handled)
@@ -185,3 +271,98 @@ func Test_fastPrimitiveEqual(t *testing.T) {
 		assert.NoError(t, err)
 	})
 }
+
+func Test_matchElements(t *testing.T) {
+	eq := func(exp, act []int) func(ei, ai int) error {
+		return func(ei, ai int) error {
+			if exp[ei] == act[ai] || exp[ei] == 0 {
+				return nil
+			}
+			return errors.New("differ")
+		}
+	}
+
+	t.Run("permutation succeeds", func(t *testing.T) {
+		exp, act := []int{1, 2, 3}, []int{3, 1, 2}
+		assert.Equal(t, []int{1, 2, 0}, matchElements(3, 3, eq(exp, act)))
+	})
+
+	t.Run("wildcard reassigned by augmenting path succeeds", func(t *testing.T) {
+		// 0 matches anything; greedy first-fit would give it actual 1 and
+		// leave expected 1 unmatched.
+		exp, act := []int{0, 1}, []int{1, 2}
+		assert.Equal(t, []int{1, 0}, matchElements(2, 2, eq(exp, act)))
+	})
+
+	t.Run("unmatched element is -1", func(t *testing.T) {
+		exp, act := []int{1, 4}, []int{1, 2}
+		assert.Equal(t, []int{0, -1}, matchElements(2, 2, eq(exp, act)))
+	})
+
+	t.Run("each pair tried once", func(t *testing.T) {
+		tries := map[[2]int]int{}
+		matchElements(3, 3, func(ei, ai int) error {
+			tries[[2]int{ei, ai}]++
+			return nil
+		})
+		for pair, n := range tries {
+			assert.Equal(t, 1, n, "pair %v", pair)
+		}
+	})
+}
+
+func Test_matchPrimitives(t *testing.T) {
+	t.Run("numbers match across types", func(t *testing.T) {
+		got, ok := matchPrimitives([]any{1, 2.5, uint8(3)}, []any{float64(3), float32(2.5), int64(1)})
+		require.True(t, ok)
+		assert.Equal(t, []int{2, 1, 0}, got)
+	})
+
+	t.Run("duplicates consume distinct elements", func(t *testing.T) {
+		got, ok := matchPrimitives([]any{"a", "a", "b"}, []any{"a", "b", "c"})
+		require.True(t, ok)
+		assert.Equal(t, []int{0, -1, 1}, got)
+	})
+
+	t.Run("kinds do not mix", func(t *testing.T) {
+		got, ok := matchPrimitives([]any{"1", true, nil}, []any{1, 1, nil})
+		require.True(t, ok)
+		assert.Equal(t, []int{-1, -1, 2}, got)
+	})
+
+	t.Run("NaN matches nothing", func(t *testing.T) {
+		got, ok := matchPrimitives([]any{math.NaN()}, []any{math.NaN()})
+		require.True(t, ok)
+		assert.Equal(t, []int{-1}, got)
+	})
+
+	t.Run("non-primitive element falls back", func(t *testing.T) {
+		_, ok := matchPrimitives([]any{1}, []any{jwalk.Document{}})
+		assert.False(t, ok)
+		_, ok = matchPrimitives([]any{&Any{}}, []any{1})
+		assert.False(t, ok)
+	})
+
+	t.Run("large integer falls back", func(t *testing.T) {
+		_, ok := matchPrimitives([]any{int64(1<<53 + 1)}, []any{int64(1 << 53)})
+		assert.False(t, ok)
+	})
+}
+
+func Test_tolerance(t *testing.T) {
+	a, b := 0.1, 0.2
+	t.Run("absolute tolerance succeeds", func(t *testing.T) {
+		assert.True(t, tolerance{abs: 1e-9}.close(a+b, 0.3))
+		assert.False(t, tolerance{abs: 1e-9}.close(0.3, 0.31))
+	})
+
+	t.Run("relative tolerance scales with magnitude", func(t *testing.T) {
+		assert.True(t, tolerance{rel: 0.01}.close(1000, 1009))
+		assert.False(t, tolerance{rel: 0.01}.close(1, 1.02))
+	})
+
+	t.Run("zero tolerance requires equality", func(t *testing.T) {
+		assert.True(t, tolerance{}.close(1, 1))
+		assert.False(t, tolerance{}.close(a+b, 0.3))
+	})
+}
@@ -1,23 +1,79 @@
 package testequals
 
 import (
+	"errors"
 	"fmt"
-	"strings"
 )
 
-// MismatchError describes a single comparison failure. Path segments are
-// formatted using dot notation for object keys and [index] for array indices
-// (e.g. .user.address[0].city). Message holds a human‑readable description.
+// MismatchKind classifies the failure described by a MismatchError.
+type MismatchKind int
+
+const (
+	_ MismatchKind = iota
+	// KeyNotFound reports an expected object key missing from the actual value.
+	KeyNotFound
+	// TypeMismatch reports an actual value of the wrong JSON/Go type.
+	TypeMismatch
+	// ValueMismatch reports a primitive value that differs from the expected one.
+	ValueMismatch
+	// LengthMismatch reports an array whose length differs from the expected one.
+	LengthMismatch
+	// UnexpectedKey reports an actual object key that must not be present.
+	UnexpectedKey
+	// RuleFailed reports a rule (directive) whose condition was not satisfied.
+	RuleFailed
+)
+
+var mismatchKindNames = [...]string{
+	KeyNotFound:    "KeyNotFound",
+	TypeMismatch:   "TypeMismatch",
+	ValueMismatch:  "ValueMismatch",
+	LengthMismatch: "LengthMismatch",
+	UnexpectedKey:  "UnexpectedKey",
+	RuleFailed:     "RuleFailed",
+}
+
+func (k MismatchKind) String() string {
+	if k > 0 && int(k) < len(mismatchKindNames) {
+		return mismatchKindNames[k]
+	}
+	return fmt.Sprintf("MismatchKind(%d)", int(k))
+}
+
+// MismatchError describes a single comparison failure. Path locates the
+// failing value; its String form uses dot notation for object keys and [index]
+// for array indices (e.g. .user.address[0].city), and it can also be rendered
+// as a JSON Pointer or JSONPath. Message holds a human‑readable description.
+//
+// The remaining fields describe the failure for tooling: Kind classifies it,
+// Expected and Actual hold the values involved (the lengths for
+// LengthMismatch, the directive operand for rule failures), Rule names the
+// directive that failed (e.g. "$regex") and Err holds the underlying cause, if
+// any, for use with errors.Is / errors.As.
 type MismatchError struct {
-	Path    []string
-	Message string
+	Path     Path
+	Message  string
+	Kind     MismatchKind
+	Expected any
+	Actual   any
+	Rule     string
+	Err      error
 }
 
 func (e *MismatchError) Error() string {
 	if len(e.Path) == 0 {
 		return e.Message
 	}
-	return fmt.Sprintf("%s: %s", strings.Join(e.Path, ""), e.Message)
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+func (e *MismatchError) Unwrap() error { return e.Err }
+
+// at returns a copy of e whose path is prefixed with path.
+func (e *MismatchError) at(path Path) *MismatchError {
+	m := *e
+	m.Path = append(append(make(Path, 0, len(path)+len(e.Path)), path...), e.Path...)
+	return &m
 }
 
 // MultiError aggregates multiple mismatches produced when CollectAll is
@@ -43,14 +99,46 @@ func (e *MultiError) Unwrap() error {
 	return e.Mismatches[0]
 }
 
-func mismatch(path []string, msg string) *MismatchError {
-	return &MismatchError{Path: append([]string{}, path...), Message: msg}
+// newMismatch builds a path-less mismatch of the given kind; callers prefix the
+// path when reporting.
+func newMismatch(kind MismatchKind, expected, actual any, msg string) *MismatchError {
+	return &MismatchError{Message: msg, Kind: kind, Expected: expected, Actual: actual}
+}
+
+// ruleMismatch builds a path-less mismatch produced by the named directive.
+// The message is formatted with fmt.Errorf so a %w verb sets Err.
+func ruleMismatch(rule string, kind MismatchKind, expected, actual any, format string, args ...any) *MismatchError {
+	err := fmt.Errorf(format, args...)
+	return &MismatchError{
+		Message:  err.Error(),
+		Kind:     kind,
+		Expected: expected,
+		Actual:   actual,
+		Rule:     rule,
+		Err:      errors.Unwrap(err),
+	}
+}
+
+// mismatchesOf flattens an error returned by a comparison into mismatches.
+// Errors other than *MismatchError and *MultiError become a single RuleFailed
+// mismatch wrapping err.
+func mismatchesOf(err error) []*MismatchError {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *MismatchError:
+		return []*MismatchError{e}
+	case *MultiError:
+		return e.Mismatches
+	default:
+		return []*MismatchError{{Message: err.Error(), Kind: RuleFailed, Err: err}}
+	}
 }
 
-func keySeg(k string) string {
-	return "." + k
+func keySeg(k string) PathSegment {
+	return KeySegment(k)
 }
 
-func indexSeg(i int) string {
-	return fmt.Sprintf("[%d]", i)
+func indexSeg(i int) PathSegment {
+	return IndexSegment(i)
 }
@@ -0,0 +1,52 @@
+package testequals
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMismatchKindString(t *testing.T) {
+	t.Run("known kind returns name", func(t *testing.T) {
+		assert.Equal(t, "LengthMismatch", LengthMismatch.String())
+	})
+
+	t.Run("unknown kind returns placeholder", func(t *testing.T) {
+		assert.Equal(t, "MismatchKind(0)", MismatchKind(0).String())
+	})
+}
+
+func TestMismatchError(t *testing.T) {
+	t.Run("at prefixes path without aliasing", func(t *testing.T) {
+		m := &MismatchError{Path: Path{KeySegment("b")}, Message: "x", Kind: ValueMismatch}
+		got := m.at(Path{KeySegment("a")})
+		assert.Equal(t, Path{KeySegment("a"), KeySegment("b")}, got.Path)
+		assert.Equal(t, Path{KeySegment("b")}, m.Path)
+		assert.Equal(t, ValueMismatch, got.Kind)
+	})
+
+	t.Run("unwrap returns cause", func(t *testing.T) {
+		m := ruleMismatch("$x", RuleFailed, nil, nil, "failed: %w", assert.AnError)
+		assert.ErrorIs(t, m, assert.AnError)
+		assert.Equal(t, "failed: "+assert.AnError.Error(), m.Message)
+	})
+}
+
+func Test_mismatchesOf(t *testing.T) {
+	t.Run("plain error becomes rule failure", func(t *testing.T) {
+		got := mismatchesOf(assert.AnError)
+		if assert.Len(t, got, 1) {
+			assert.Equal(t, RuleFailed, got[0].Kind)
+			assert.ErrorIs(t, got[0], assert.AnError)
+		}
+	})
+
+	t.Run("multi error flattens", func(t *testing.T) {
+		m := &MultiError{Mismatches: []*MismatchError{{Message: "a"}, {Message: "b"}}}
+		assert.Len(t, mismatchesOf(m), 2)
+	})
+
+	t.Run("nil returns none", func(t *testing.T) {
+		assert.Empty(t, mismatchesOf(nil))
+	})
+}
@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/calumari/jwalk"
-
 	"github.com/calumari/testequals"
 )
 
@@ -18,13 +16,41 @@ import (
 //	$required         field must exist and not be zero / nil
 //	$any              wildcard (always passes)
 //	$regex            string must match pattern
+//	$startsWith       string prefix (optional ignoreCase / trimSpace / collapseWhitespace)
+//	$endsWith         string suffix
+//	$containsString   substring
+//	$ieq              case-insensitive string equality
 //	$elementsMatch    order-insensitive exact multiset match
+//	$contains         array holds matches for the listed elements (any order)
+//	$containsInOrder  array holds matches for the listed elements in order
+//	$keyedBy          array of objects compared as a map keyed by identity fields
+//	$each             every array element matches the expectation
+//	$some             at least one array element matches the expectation
+//	$count            number of matching array elements within bounds
+//	$parseJSON        string holding JSON that matches the nested expectation
+//	$base64           decoded base64 string, bytes or JSON matches the expectation
+//	$jwt              decoded JWT header / claims / signature match the expectation
+//	$url              URL scheme / host / path / query / fragment match the expectation
+//	$type             JSON type name, or an array of allowed names
+//	$format           string in a named format (uuid, email, date-time, ...)
+//	$after/$before    time after / before an RFC 3339 instant or "now"
+//	$timeEq           same instant as an RFC 3339 time, in any offset
+//	$within           time within delta of an instant or "now"
 //	$length length    constraints (eq / lt / lte / gt / gte)
 //	$empty            true => empty, false => non-empty
 //	$lt/$lte/$gt/$gte numeric comparisons
+//	$approx           number within abs / rel tolerance of value
 //	$in               membership
 //	$and/$or/$nor     logical combinators over inline expectations
 //	$not              negation
+//	$exists/$absent   key presence (evaluated even when the key is missing)
+//	$optional         match only if the key is present
+//	$capture          bind the actual value under a name (see Tester.TestCaptures)
+//	$ref              value must equal the one captured under a name
+//	$defs             named expectations, first member of a document
+//	$use              value must match a named definition (may be recursive)
+//	$subset/$strict   override object/array comparison modes for a subtree
+//	$mode             set object and/or array modes explicitly
 const a = `{
   "user": {
     "$eq": {
@@ -40,7 +66,9 @@ const a = `{
       "score": { "$and": [{ "$gt": 10 }, { "$lt": 100 }] }, 
       "disallowed": { "$nor": [{ "$eq": "banned" }, { "$eq": "disabled" }] }, 
       "note": { "$not": { "$regex": "ERROR" } }, 
-      "different": { "$or": [{"$eq":123}] }
+      "different": { "$or": [{"$eq":123}] },
+      "deleted": { "$absent": true },
+      "nickname": { "$optional": { "$regex": "^[a-z]+$" } }
     }
   }
 }`
@@ -66,29 +94,9 @@ const b = `{
 }`
 
 func main() {
-	reg := jwalk.DefaultRegistry()
-	x := []*jwalk.Directive{
-		testequals.TestEqualDirective,
-		testequals.TestNotEqualDirective,
-		testequals.TestNilDirective,
-		testequals.TestRequiredDirective,
-		testequals.TestAnyDirective,
-		testequals.TestMatchStringDirective,
-		testequals.TestElementsMatchDirective,
-		testequals.TestLengthDirective,
-		testequals.TestEmptyDirective,
-		testequals.TestLessThanDirective,
-		testequals.TestLessThanOrEqualDirective,
-		testequals.TestGreaterThanDirective,
-		testequals.TestGreaterThanOrEqualDirective,
-		testequals.TestInDirective,
-		testequals.TestAndDirective,
-		testequals.TestOrDirective,
-		testequals.TestNorDirective,
-		testequals.TestNotDirective,
-	}
-	for _, v := range x {
-		reg.Register(v)
+	reg, err := testequals.NewRegistry()
+	if err != nil {
+		panic(err)
 	}
 
 	var expected any
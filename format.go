@@ -0,0 +1,163 @@
+package testequals
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownFormat is wrapped by "$format" mismatches naming a format that was
+// never registered.
+var ErrUnknownFormat = errors.New("unknown format")
+
+var (
+	formatsMu sync.RWMutex
+	formats   = map[string]func(string) error{
+		"uuid":      checkUUID,
+		"email":     checkEmail,
+		"uri":       checkURI,
+		"date-time": checkDateTime,
+		"date":      checkDate,
+		"ipv4":      checkIPv4,
+		"ipv6":      checkIPv6,
+		"hostname":  checkHostname,
+		"base64":    checkBase64,
+		"base64url": checkBase64URL,
+	}
+)
+
+// RegisterFormat makes a string format available to "$format" under name,
+// replacing any format registered under the same name. check returns nil when
+// its argument is valid and an error describing the problem otherwise. It is
+// safe for concurrent use, typically from an init function or TestMain.
+func RegisterFormat(name string, check func(string) error) {
+	if check == nil {
+		panic("testequals: RegisterFormat check is nil")
+	}
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats[name] = check
+}
+
+func lookupFormat(name string) (func(string) error, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	check, ok := formats[name]
+	return check, ok
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func checkUUID(s string) error {
+	if !uuidPattern.MatchString(s) {
+		return errors.New("expected 8-4-4-4-12 hexadecimal digits")
+	}
+	return nil
+}
+
+// checkEmail accepts a bare RFC 5322 address such as "a@example.com", without
+// a display name or angle brackets.
+func checkEmail(s string) error {
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return err
+	}
+	if addr.Name != "" || addr.Address != s {
+		return errors.New("expected a bare address")
+	}
+	return nil
+}
+
+// checkURI accepts absolute URIs, i.e. those with a scheme.
+func checkURI(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if u.Scheme == "" {
+		return errors.New("missing scheme")
+	}
+	return nil
+}
+
+func checkDateTime(s string) error {
+	_, err := time.Parse(time.RFC3339, s)
+	return err
+}
+
+func checkDate(s string) error {
+	_, err := time.Parse(time.DateOnly, s)
+	return err
+}
+
+func checkIPv4(s string) error {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return err
+	}
+	if !addr.Is4() {
+		return errors.New("not an IPv4 address")
+	}
+	return nil
+}
+
+func checkIPv6(s string) error {
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return err
+	}
+	if !addr.Is6() {
+		return errors.New("not an IPv6 address")
+	}
+	return nil
+}
+
+// checkHostname accepts RFC 1123 host names: dot-separated labels of up to 63
+// letters, digits and hyphens, not starting or ending with a hyphen, with a
+// total length of at most 253.
+func checkHostname(s string) error {
+	if s == "" || len(s) > 253 {
+		return fmt.Errorf("length %d out of range", len(s))
+	}
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("label %q length out of range", label)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label %q starts or ends with a hyphen", label)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("label %q contains %q", label, c)
+			}
+		}
+	}
+	return nil
+}
+
+func checkBase64(s string) error {
+	_, err := base64.StdEncoding.Strict().DecodeString(s)
+	return err
+}
+
+// checkBase64URL accepts the URL-safe alphabet with or without padding.
+func checkBase64URL(s string) error {
+	_, err := decodeBase64(base64.RawURLEncoding.Strict(), s)
+	return err
+}
+
+// decodeBase64 decodes s with the unpadded encoding raw or, when s ends in
+// padding, with its padded form, so that padding must be complete and correct.
+func decodeBase64(raw *base64.Encoding, s string) ([]byte, error) {
+	if strings.HasSuffix(s, "=") {
+		return raw.WithPadding(base64.StdPadding).DecodeString(s)
+	}
+	return raw.DecodeString(s)
+}
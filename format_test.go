@@ -0,0 +1,67 @@
+package testequals
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		valid  []string
+		bad    []string
+	}{
+		{"uuid", []string{"123e4567-e89b-12d3-a456-426614174000", "123E4567-E89B-12D3-A456-426614174000"}, []string{"123e4567e89b12d3a456426614174000", "123e4567-e89b-12d3-a456-42661417400g", ""}},
+		{"email", []string{"a@example.com", "first.last+tag@sub.example.org"}, []string{"example.com", "Alice <a@example.com>", "a@"}},
+		{"uri", []string{"https://example.com/a?b=c", "urn:isbn:0451450523"}, []string{"/relative/path", "http://[::1", ""}},
+		{"date-time", []string{"2024-05-01T12:30:00Z", "2024-05-01T12:30:00.123+02:00"}, []string{"2024-05-01", "2024-05-01 12:30:00Z", "2024-13-01T00:00:00Z"}},
+		{"date", []string{"2024-05-01"}, []string{"2024-5-1", "2024-05-01T00:00:00Z"}},
+		{"ipv4", []string{"192.168.0.1"}, []string{"256.0.0.1", "::1", "example.com"}},
+		{"ipv6", []string{"::1", "2001:db8::ff00:42:8329"}, []string{"192.168.0.1", "2001:db8::g"}},
+		{"hostname", []string{"example.com", "a-b.c1", "localhost"}, []string{"-a.com", "a..com", "a_b.com", strings.Repeat("a", 64) + ".com"}},
+		{"base64", []string{"aGVsbG8=", ""}, []string{"aGVsbG8", "a-b_"}},
+		{"base64url", []string{"aGVsbG8", "aGVsbG8=", "a-b_"}, []string{"a+b/", "a b", "===", "aGVsbG8==", "aGk=="}},
+	}
+	for _, tt := range tests {
+		check, ok := lookupFormat(tt.format)
+		if !assert.True(t, ok, tt.format) {
+			continue
+		}
+		for _, s := range tt.valid {
+			t.Run(tt.format+" "+s+" succeeds", func(t *testing.T) {
+				assert.NoError(t, check(s))
+			})
+		}
+		for _, s := range tt.bad {
+			t.Run(tt.format+" "+s+" returns error", func(t *testing.T) {
+				assert.Error(t, check(s))
+			})
+		}
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	errSKU := errors.New("bad sku")
+	RegisterFormat("test-sku", func(s string) error {
+		if !strings.HasPrefix(s, "SKU-") {
+			return errSKU
+		}
+		return nil
+	})
+
+	t.Run("registered format succeeds", func(t *testing.T) {
+		assert.NoError(t, New().Test(Format("test-sku"), "SKU-1"))
+	})
+
+	t.Run("registered format wraps check error", func(t *testing.T) {
+		err := New().Test(Format("test-sku"), "1")
+		assert.ErrorIs(t, err, errSKU)
+	})
+
+	t.Run("nil check panics", func(t *testing.T) {
+		assert.Panics(t, func() { RegisterFormat("test-nil", nil) })
+	})
+}
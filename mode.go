@@ -0,0 +1,83 @@
+package testequals
+
+import "fmt"
+
+// ObjectMode selects how expected documents are compared with actual ones.
+type ObjectMode int
+
+const (
+	_ ObjectMode = iota
+	// ObjectSubset requires every expected key to match and ignores extra
+	// actual keys. It is the default.
+	ObjectSubset
+	// ObjectStrict additionally reports every actual key missing from the
+	// expected document as UnexpectedKey.
+	ObjectStrict
+)
+
+var objectModeNames = [...]string{
+	ObjectSubset: "subset",
+	ObjectStrict: "strict",
+}
+
+func (m ObjectMode) String() string {
+	if m > 0 && int(m) < len(objectModeNames) {
+		return objectModeNames[m]
+	}
+	return fmt.Sprintf("ObjectMode(%d)", int(m))
+}
+
+// UnmarshalText parses a mode name as used by the "$mode" directive.
+func (m *ObjectMode) UnmarshalText(text []byte) error {
+	for i, name := range objectModeNames {
+		if i > 0 && name == string(text) {
+			*m = ObjectMode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown object mode %q", text)
+}
+
+// ArrayMode selects how expected arrays are compared with actual ones.
+type ArrayMode int
+
+const (
+	_ ArrayMode = iota
+	// ArrayStrict requires the same length and pairwise matching elements in
+	// order. It is the default.
+	ArrayStrict
+	// ArrayOrderedPrefix matches expected elements in order against the
+	// beginning of the actual array, which may hold extra trailing elements.
+	ArrayOrderedPrefix
+	// ArrayUnordered requires the same length and matches every expected
+	// element against a distinct actual element in any order.
+	ArrayUnordered
+	// ArrayUnorderedSubset matches every expected element against a distinct
+	// actual element in any order, allowing extra actual elements.
+	ArrayUnorderedSubset
+)
+
+var arrayModeNames = [...]string{
+	ArrayStrict:          "strict",
+	ArrayOrderedPrefix:   "orderedPrefix",
+	ArrayUnordered:       "unordered",
+	ArrayUnorderedSubset: "unorderedSubset",
+}
+
+func (m ArrayMode) String() string {
+	if m > 0 && int(m) < len(arrayModeNames) {
+		return arrayModeNames[m]
+	}
+	return fmt.Sprintf("ArrayMode(%d)", int(m))
+}
+
+// UnmarshalText parses a mode name as used by the "$mode" directive.
+func (m *ArrayMode) UnmarshalText(text []byte) error {
+	for i, name := range arrayModeNames {
+		if i > 0 && name == string(text) {
+			*m = ArrayMode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown array mode %q", text)
+}
@@ -0,0 +1,47 @@
+package testequals
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObjectMode(t *testing.T) {
+	t.Run("names round trip succeeds", func(t *testing.T) {
+		for _, m := range []ObjectMode{ObjectSubset, ObjectStrict} {
+			var got ObjectMode
+			require.NoError(t, got.UnmarshalText([]byte(m.String())))
+			assert.Equal(t, m, got)
+		}
+	})
+
+	t.Run("unknown name returns error", func(t *testing.T) {
+		var m ObjectMode
+		assert.Error(t, m.UnmarshalText([]byte("lenient")))
+		assert.Error(t, m.UnmarshalText([]byte("")))
+	})
+
+	t.Run("unknown value string", func(t *testing.T) {
+		assert.Equal(t, "ObjectMode(0)", ObjectMode(0).String())
+	})
+}
+
+func TestArrayMode(t *testing.T) {
+	t.Run("names round trip succeeds", func(t *testing.T) {
+		for _, m := range []ArrayMode{ArrayStrict, ArrayOrderedPrefix, ArrayUnordered, ArrayUnorderedSubset} {
+			var got ArrayMode
+			require.NoError(t, got.UnmarshalText([]byte(m.String())))
+			assert.Equal(t, m, got)
+		}
+	})
+
+	t.Run("unknown name returns error", func(t *testing.T) {
+		var m ArrayMode
+		assert.Error(t, m.UnmarshalText([]byte("sorted")))
+	})
+
+	t.Run("unknown value string", func(t *testing.T) {
+		assert.Equal(t, "ArrayMode(9)", ArrayMode(9).String())
+	})
+}
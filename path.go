@@ -0,0 +1,220 @@
+package testequals
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathSegment is a single step of a Path: an object key, or an array index
+// when IsIndex is set. An index segment with Keys identifies the element by
+// the values of its key fields instead (see KeyedSegment).
+type PathSegment struct {
+	Key     string
+	Index   int
+	IsIndex bool
+	Keys    []KeyMatch
+}
+
+// KeyMatch is a key field value identifying an array element, as used by
+// "$keyedBy".
+type KeyMatch struct {
+	Key   string
+	Value any
+}
+
+// KeySegment returns a segment addressing object key k.
+func KeySegment(k string) PathSegment {
+	return PathSegment{Key: k}
+}
+
+// IndexSegment returns a segment addressing array index i.
+func IndexSegment(i int) PathSegment {
+	return PathSegment{Index: i, IsIndex: true}
+}
+
+// KeyedSegment returns a segment addressing the array element identified by
+// keys, found at index i of the actual array, or -1 when there is no such
+// element.
+func KeyedSegment(i int, keys ...KeyMatch) PathSegment {
+	return PathSegment{Index: i, IsIndex: true, Keys: keys}
+}
+
+// Path locates a value within a compared document, from the root down.
+type Path []PathSegment
+
+// String renders the path in the dotted form used by mismatch messages, e.g.
+// .user.address[0].city. Keys that are empty or contain '.', '[', ']' or '"'
+// are rendered quoted in brackets (e.g. ["a.b"]) so the result is unambiguous.
+// Keyed segments render their key fields, e.g. .users[id=42].email.
+func (p Path) String() string {
+	var b strings.Builder
+	for _, s := range p {
+		switch {
+		case len(s.Keys) > 0:
+			b.WriteByte('[')
+			for i, k := range s.Keys {
+				if i > 0 {
+					b.WriteByte(',')
+				}
+				b.WriteString(k.Key)
+				b.WriteByte('=')
+				if str, ok := k.Value.(string); ok {
+					b.WriteString(strconv.Quote(str))
+				} else {
+					writeSelectorLiteral(&b, k.Value)
+				}
+			}
+			b.WriteByte(']')
+		case s.IsIndex:
+			b.WriteByte('[')
+			b.WriteString(strconv.Itoa(s.Index))
+			b.WriteByte(']')
+		case s.Key == "" || strings.ContainsAny(s.Key, `.[]"`):
+			b.WriteByte('[')
+			b.WriteString(strconv.Quote(s.Key))
+			b.WriteByte(']')
+		default:
+			b.WriteByte('.')
+			b.WriteString(s.Key)
+		}
+	}
+	return b.String()
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// JSONPointer renders the path as an RFC 6901 JSON Pointer, e.g.
+// /user/address/0/city. The root path renders as the empty string. Keyed
+// segments use the index of the element in the actual array. An element
+// missing from the actual array has no location, so the pointer stops at the
+// array holding it (e.g. /users for .users[id=42]); "-" would denote the
+// position past the end of the array instead.
+func (p Path) JSONPointer() string {
+	var b strings.Builder
+	for _, s := range p {
+		if s.IsIndex && s.Index < 0 {
+			break
+		}
+		b.WriteByte('/')
+		if s.IsIndex {
+			b.WriteString(strconv.Itoa(s.Index))
+			continue
+		}
+		b.WriteString(pointerEscaper.Replace(s.Key))
+	}
+	return b.String()
+}
+
+// JSONPath renders the path as a JSONPath expression, e.g.
+// $.user.address[0].city. Keys that are not plain identifiers use the
+// bracket notation with a single-quoted, escaped name (e.g. $['a.b']). Keyed
+// segments render as filter selectors, e.g. $.users[?@.id==42].email.
+func (p Path) JSONPath() string {
+	var b strings.Builder
+	b.WriteByte('$')
+	for _, s := range p {
+		switch {
+		case len(s.Keys) > 0:
+			b.WriteString("[?")
+			for i, k := range s.Keys {
+				if i > 0 {
+					b.WriteString(" && ")
+				}
+				b.WriteByte('@')
+				if isIdentifier(k.Key) {
+					b.WriteByte('.')
+					b.WriteString(k.Key)
+				} else {
+					b.WriteString("['")
+					writeJSONPathString(&b, k.Key)
+					b.WriteString("']")
+				}
+				b.WriteString("==")
+				if str, ok := k.Value.(string); ok {
+					b.WriteByte('\'')
+					writeJSONPathString(&b, str)
+					b.WriteByte('\'')
+				} else {
+					writeSelectorLiteral(&b, k.Value)
+				}
+			}
+			b.WriteByte(']')
+		case s.IsIndex:
+			b.WriteByte('[')
+			b.WriteString(strconv.Itoa(s.Index))
+			b.WriteByte(']')
+		case isIdentifier(s.Key):
+			b.WriteByte('.')
+			b.WriteString(s.Key)
+		default:
+			b.WriteString("['")
+			writeJSONPathString(&b, s.Key)
+			b.WriteString("']")
+		}
+	}
+	return b.String()
+}
+
+// isIdentifier reports whether k can use the JSONPath dot notation.
+func isIdentifier(k string) bool {
+	if k == "" {
+		return false
+	}
+	for i, r := range k {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// writeJSONPathString writes k escaped for a single-quoted JSONPath name
+// (RFC 9535).
+func writeJSONPathString(b *strings.Builder, k string) {
+	for _, r := range k {
+		switch r {
+		case '\'':
+			b.WriteString(`\'`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				b.WriteString(`\u00`)
+				b.WriteString(strconv.FormatInt(int64(r)>>4, 16))
+				b.WriteString(strconv.FormatInt(int64(r)&0xf, 16))
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+}
+
+// writeSelectorLiteral writes a non-string key value as a JSON literal.
+func writeSelectorLiteral(b *strings.Builder, v any) {
+	switch x := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(x))
+	default:
+		if f, ok := toFloat64(x); ok {
+			b.WriteString(strconv.FormatFloat(f, 'f', -1, 64))
+			return
+		}
+		fmt.Fprint(b, x)
+	}
+}
@@ -0,0 +1,151 @@
+package testequals
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type patternKind int
+
+const (
+	patternKey        patternKind = iota // a literal object key
+	patternIndex                         // a literal array index
+	patternWildcard                      // any single key or index: * or [*]
+	patternDescendant                    // zero or more segments: ** or ..
+)
+
+type patternSegment struct {
+	kind  patternKind
+	key   string
+	index int
+}
+
+func (s patternSegment) matches(seg PathSegment) bool {
+	switch s.kind {
+	case patternKey:
+		return !seg.IsIndex && seg.Key == s.key
+	case patternIndex:
+		return seg.IsIndex && seg.Index == s.index
+	default:
+		return true
+	}
+}
+
+// pathPattern selects nodes of a compared document by their Path.
+type pathPattern []patternSegment
+
+// match reports whether p addresses exactly the node described by the pattern.
+func (pat pathPattern) match(p Path) bool {
+	for len(pat) > 0 {
+		s := pat[0]
+		if s.kind == patternDescendant {
+			for i := 0; i <= len(p); i++ {
+				if pat[1:].match(p[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(p) == 0 || !s.matches(p[0]) {
+			return false
+		}
+		pat, p = pat[1:], p[1:]
+	}
+	return len(p) == 0
+}
+
+// parsePathPattern parses a glob or JSONPath-style pattern. The leading "$" is
+// optional; keys are written as .name or ['name'] (or ["name"]), indices as
+// [n], "*" or [*] matches any single key or index and "**" or ".." matches any
+// number of segments, including none. For example "**.updatedAt",
+// "$..updatedAt" and "$.items[*].id" are all valid patterns.
+func parsePathPattern(s string) (pathPattern, error) {
+	rest := s
+	if rest == "$" || strings.HasPrefix(rest, "$.") || strings.HasPrefix(rest, "$[") {
+		rest = rest[1:]
+	}
+	var pat pathPattern
+	fail := func(format string, args ...any) (pathPattern, error) {
+		return nil, fmt.Errorf("invalid path pattern %q: %s", s, fmt.Sprintf(format, args...))
+	}
+	for first := true; rest != ""; first = false {
+		switch {
+		case strings.HasPrefix(rest, ".."):
+			pat = append(pat, patternSegment{kind: patternDescendant})
+			rest = rest[2:]
+			if rest == "" || rest[0] == '[' {
+				continue
+			}
+		case rest[0] == '.':
+			rest = rest[1:]
+		case rest[0] == '[':
+			seg, n, err := parseBracketSegment(rest)
+			if err != nil {
+				return fail("%v", err)
+			}
+			pat = append(pat, seg)
+			rest = rest[n:]
+			continue
+		case !first:
+			return fail("unexpected %q", rest[0])
+		}
+		n := strings.IndexAny(rest, ".[")
+		if n < 0 {
+			n = len(rest)
+		}
+		name := rest[:n]
+		if strings.ContainsRune(name, ']') {
+			return fail("unexpected ] in key %q", name)
+		}
+		switch name {
+		case "":
+			return fail("empty key")
+		case "*":
+			pat = append(pat, patternSegment{kind: patternWildcard})
+		case "**":
+			pat = append(pat, patternSegment{kind: patternDescendant})
+		default:
+			pat = append(pat, patternSegment{kind: patternKey, key: name})
+		}
+		rest = rest[n:]
+	}
+	return pat, nil
+}
+
+// parseBracketSegment parses a leading [*], [n], ['name'] or ["name"] and
+// returns the segment along with the number of bytes consumed. Within quoted
+// names a backslash escapes the following character.
+func parseBracketSegment(s string) (patternSegment, int, error) {
+	if strings.HasPrefix(s, "[*]") {
+		return patternSegment{kind: patternWildcard}, 3, nil
+	}
+	if len(s) > 1 && (s[1] == '\'' || s[1] == '"') {
+		quote := s[1]
+		var b strings.Builder
+		for i := 2; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == '\\' && i+1 < len(s):
+				i++
+				b.WriteByte(s[i])
+			case c == quote:
+				if i+1 >= len(s) || s[i+1] != ']' {
+					return patternSegment{}, 0, fmt.Errorf("expected ] after quoted key")
+				}
+				return patternSegment{kind: patternKey, key: b.String()}, i + 2, nil
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return patternSegment{}, 0, fmt.Errorf("unterminated quoted key")
+	}
+	end := strings.IndexByte(s, ']')
+	if end < 0 {
+		return patternSegment{}, 0, fmt.Errorf("unterminated [")
+	}
+	i, err := strconv.Atoi(s[1:end])
+	if err != nil || i < 0 {
+		return patternSegment{}, 0, fmt.Errorf("invalid index %q", s[1:end])
+	}
+	return patternSegment{kind: patternIndex, index: i}, end + 1, nil
+}
@@ -0,0 +1,82 @@
+package testequals
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parsePathPattern(t *testing.T) {
+	t.Run("dotted keys and indices succeeds", func(t *testing.T) {
+		pat, err := parsePathPattern("$.items[2].id")
+		require.NoError(t, err)
+		assert.Equal(t, pathPattern{
+			{kind: patternKey, key: "items"},
+			{kind: patternIndex, index: 2},
+			{kind: patternKey, key: "id"},
+		}, pat)
+	})
+
+	t.Run("wildcards and descendants succeeds", func(t *testing.T) {
+		pat, err := parsePathPattern("**.a.*[*]..b")
+		require.NoError(t, err)
+		assert.Equal(t, pathPattern{
+			{kind: patternDescendant},
+			{kind: patternKey, key: "a"},
+			{kind: patternWildcard},
+			{kind: patternWildcard},
+			{kind: patternDescendant},
+			{kind: patternKey, key: "b"},
+		}, pat)
+	})
+
+	t.Run("quoted keys succeeds", func(t *testing.T) {
+		pat, err := parsePathPattern(`$['a.b']["it\"s"]`)
+		require.NoError(t, err)
+		assert.Equal(t, pathPattern{
+			{kind: patternKey, key: "a.b"},
+			{kind: patternKey, key: `it"s`},
+		}, pat)
+	})
+
+	t.Run("root succeeds", func(t *testing.T) {
+		pat, err := parsePathPattern("$")
+		require.NoError(t, err)
+		assert.Empty(t, pat)
+	})
+
+	for _, s := range []string{"a.", "a[", "a[x]", "a[-1]", "a['b'", "a['b'x]", "a]b"} {
+		t.Run("invalid "+s+" returns error", func(t *testing.T) {
+			_, err := parsePathPattern(s)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func Test_pathPattern_match(t *testing.T) {
+	path := Path{KeySegment("items"), IndexSegment(0), KeySegment("id")}
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{"$.items[0].id", true},
+		{"items[*].id", true},
+		{"$.items.*.id", true},
+		{"**.id", true},
+		{"$..id", true},
+		{"**", true},
+		{"$.items[1].id", false},
+		{"$.items", false},
+		{"$.items[0]['0']", false},
+		{"**.items", false},
+		{"id", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			pat, err := parsePathPattern(tt.pattern)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, pat.match(path))
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package testequals
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPath_String(t *testing.T) {
+	t.Run("keys and indices render dotted", func(t *testing.T) {
+		p := Path{KeySegment("user"), KeySegment("address"), IndexSegment(0), KeySegment("city")}
+		assert.Equal(t, ".user.address[0].city", p.String())
+	})
+
+	t.Run("ambiguous keys render quoted", func(t *testing.T) {
+		p := Path{KeySegment("a.b"), KeySegment("[0]"), KeySegment("")}
+		assert.Equal(t, `["a.b"]["[0]"][""]`, p.String())
+	})
+
+	t.Run("keyed segments render key fields", func(t *testing.T) {
+		p := Path{KeySegment("users"), KeyedSegment(3, KeyMatch{"id", 42}), KeySegment("email")}
+		assert.Equal(t, ".users[id=42].email", p.String())
+		p = Path{KeyedSegment(0, KeyMatch{"tenant", "a\"b"}, KeyMatch{"id", 1.5}, KeyMatch{"on", true})}
+		assert.Equal(t, `[tenant="a\"b",id=1.5,on=true]`, p.String())
+	})
+
+	t.Run("root renders empty", func(t *testing.T) {
+		assert.Equal(t, "", Path{}.String())
+	})
+}
+
+func TestPath_JSONPointer(t *testing.T) {
+	t.Run("keys and indices render", func(t *testing.T) {
+		p := Path{KeySegment("user"), IndexSegment(2), KeySegment("city")}
+		assert.Equal(t, "/user/2/city", p.JSONPointer())
+	})
+
+	t.Run("tilde and slash escaped", func(t *testing.T) {
+		p := Path{KeySegment("a/b"), KeySegment("m~n")}
+		assert.Equal(t, "/a~1b/m~0n", p.JSONPointer())
+	})
+
+	t.Run("keyed segments use actual index", func(t *testing.T) {
+		assert.Equal(t, "/users/3/email", Path{KeySegment("users"), KeyedSegment(3, KeyMatch{"id", 42}), KeySegment("email")}.JSONPointer())
+		assert.Equal(t, "/users", Path{KeySegment("users"), KeyedSegment(-1, KeyMatch{"id", 42})}.JSONPointer())
+	})
+
+	t.Run("root renders empty", func(t *testing.T) {
+		assert.Equal(t, "", Path{}.JSONPointer())
+	})
+}
+
+func TestPath_JSONPath(t *testing.T) {
+	t.Run("identifiers use dot notation", func(t *testing.T) {
+		p := Path{KeySegment("user"), IndexSegment(0), KeySegment("first_name")}
+		assert.Equal(t, "$.user[0].first_name", p.JSONPath())
+	})
+
+	t.Run("other keys use escaped bracket notation", func(t *testing.T) {
+		p := Path{KeySegment("a.b"), KeySegment("it's"), KeySegment(`back\slash`), KeySegment("1st"), KeySegment("x\n\x01")}
+		assert.Equal(t, `$['a.b']['it\'s']['back\\slash']['1st']['x\n\u0001']`, p.JSONPath())
+	})
+
+	t.Run("keyed segments render filters", func(t *testing.T) {
+		p := Path{KeySegment("users"), KeyedSegment(3, KeyMatch{"id", 42}), KeySegment("email")}
+		assert.Equal(t, "$.users[?@.id==42].email", p.JSONPath())
+		p = Path{KeyedSegment(-1, KeyMatch{"a.b", "it's"}, KeyMatch{"n", nil})}
+		assert.Equal(t, `$[?@['a.b']=='it\'s' && @.n==null]`, p.JSONPath())
+	})
+
+	t.Run("root renders dollar", func(t *testing.T) {
+		assert.Equal(t, "$", Path{}.JSONPath())
+	})
+}
@@ -0,0 +1,115 @@
+package testequals
+
+import (
+	"fmt"
+
+	"github.com/calumari/jwalk"
+)
+
+// builtinDirective binds a builtin decoder to its short name so it can be
+// registered under any namespace.
+type builtinDirective struct {
+	name      string
+	directive func(name string) *jwalk.Directive
+}
+
+// builtins lists every builtin directive in declaration order. It is filled by
+// builtin as the Test*Directive variables are initialized, so a directive
+// declared there is picked up automatically by NewRegistry and
+// RegisterBuiltins.
+var builtins []builtinDirective
+
+// builtin records a builtin directive and returns it named under the default
+// "test" namespace.
+func builtin[T any](name string, u jwalk.Unmarshaler[T]) *jwalk.Directive {
+	b := builtinDirective{name: name, directive: func(n string) *jwalk.Directive {
+		return jwalk.NewDirective(n, u)
+	}}
+	builtins = append(builtins, b)
+	return b.directive("test." + name)
+}
+
+// RegistryOptions configures how builtin directives are registered.
+type RegistryOptions struct {
+	// Namespace is prepended to every builtin name as "<namespace>.<name>".
+	// Directives remain reachable by their short name (e.g. "$eq") while it is
+	// unambiguous within the registry. An empty namespace registers bare names.
+	Namespace string
+	// Aliases maps additional directive names to builtin short names (e.g.
+	// "equals" => "eq"). Aliases are registered under Namespace as well.
+	Aliases map[string]string
+}
+
+func DefaultRegistryOptions() RegistryOptions {
+	return RegistryOptions{
+		Namespace: "test",
+	}
+}
+
+type RegistryOption func(*RegistryOptions)
+
+// WithNamespace sets the namespace builtin directives are registered under.
+// Use a distinct namespace to avoid clashing with other directive sets, or ""
+// to register bare names.
+func WithNamespace(ns string) RegistryOption {
+	return func(o *RegistryOptions) {
+		o.Namespace = ns
+	}
+}
+
+// WithAlias registers alias as an additional name for the builtin directive
+// with the given short name.
+func WithAlias(alias, name string) RegistryOption {
+	return func(o *RegistryOptions) {
+		if o.Aliases == nil {
+			o.Aliases = make(map[string]string)
+		}
+		o.Aliases[alias] = name
+	}
+}
+
+// NewRegistry constructs a jwalk.Registry with every builtin directive
+// registered. See RegisterBuiltins.
+func NewRegistry(opts ...RegistryOption) (*jwalk.Registry, error) {
+	reg, err := jwalk.NewRegistry()
+	if err != nil {
+		return nil, err
+	}
+	if err := RegisterBuiltins(reg, opts...); err != nil {
+		return nil, err
+	}
+	return reg, nil
+}
+
+// RegisterBuiltins registers every builtin directive, plus any configured
+// aliases, into reg. It fails if a name is already registered or an alias
+// refers to an unknown builtin.
+func RegisterBuiltins(reg *jwalk.Registry, opts ...RegistryOption) error {
+	cfg := DefaultRegistryOptions()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	qualify := func(name string) string {
+		if cfg.Namespace == "" {
+			return name
+		}
+		return cfg.Namespace + "." + name
+	}
+	byName := make(map[string]builtinDirective, len(builtins))
+	for _, b := range builtins {
+		byName[b.name] = b
+		if err := reg.Register(b.directive(qualify(b.name))); err != nil {
+			return err
+		}
+	}
+	for alias, name := range cfg.Aliases {
+		b, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("alias %q refers to unknown directive %q", alias, name)
+		}
+		if err := reg.Register(b.directive(qualify(alias))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
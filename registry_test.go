@@ -0,0 +1,122 @@
+package testequals
+
+import (
+	"testing"
+
+	"github.com/calumari/jwalk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRegistry(t *testing.T) {
+	t.Run("builtin short names decode succeeds", func(t *testing.T) {
+		reg, err := NewRegistry()
+		require.NoError(t, err)
+		var got any
+		err = reg.Unmarshal([]byte(`{"a": {"$eq": 1}, "b": {"$optional": {"$regex": "^x"}}}`), &got)
+		require.NoError(t, err)
+		doc := got.(jwalk.Document)
+		assert.IsType(t, &Equal{}, doc[0].Value)
+		assert.IsType(t, &Optional{}, doc[1].Value)
+	})
+
+	t.Run("every builtin registered succeeds", func(t *testing.T) {
+		reg, err := NewRegistry()
+		require.NoError(t, err)
+		for _, b := range builtins {
+			err := reg.Register(b.directive("test." + b.name))
+			assert.Error(t, err, "directive %q should already be registered", b.name)
+		}
+	})
+
+	t.Run("every builtin decodes succeeds", func(t *testing.T) {
+		payloads := map[string]string{
+			"eq": `1`, "ne": `1`, "nil": `true`, "required": `true`, "any": `true`,
+			"regex": `"^a"`, "elementsMatch": `[1]`, "length": `1`, "empty": `true`,
+			"lt": `1`, "lte": `1`, "gt": `1`, "gte": `1`, "in": `[1]`,
+			"and": `[1]`, "or": `[1]`, "nor": `[1]`, "not": `1`,
+			"exists": `true`, "absent": `true`, "optional": `1`,
+			"subset": `{"a": 1}`, "strict": `{"a": 1}`, "mode": `{"arrays": "unordered", "value": [1]}`,
+			"contains": `[1]`, "containsInOrder": `[1]`, "keyedBy": `{"key": "id", "items": [{"id": 1}]}`,
+			"each": `1`, "some": `1`, "count": `{"match": 1, "gte": 1}`,
+			"type": `"string"`, "format": `"uuid"`,
+			"after": `"now"`, "before": `"now"`, "timeEq": `"2024-05-01T00:00:00Z"`,
+			"within": `{"of": "now", "delta": "1s"}`, "approx": `{"value": 1, "abs": 0.1}`,
+			"startsWith": `"a"`, "endsWith": `"a"`, "containsString": `"a"`, "ieq": `"a"`,
+			"parseJSON": `1`, "base64": `"a"`, "jwt": `{}`, "url": `{}`,
+			"capture": `"x"`, "ref": `"x"`, "defs": `{"m": 1}`, "use": `"m"`,
+		}
+		reg, err := NewRegistry()
+		require.NoError(t, err)
+		for _, b := range builtins {
+			payload, ok := payloads[b.name]
+			if !assert.True(t, ok, "no payload for directive %q", b.name) {
+				continue
+			}
+			delete(payloads, b.name)
+			var got any
+			err := reg.Unmarshal([]byte(`{"$test.`+b.name+`": `+payload+`}`), &got)
+			if assert.NoError(t, err, "directive %q", b.name) {
+				assert.Implements(t, (*Rule)(nil), got, "directive %q", b.name)
+			}
+		}
+		assert.Empty(t, payloads, "payloads for directives that are not builtin")
+	})
+
+	t.Run("qualified name decode succeeds", func(t *testing.T) {
+		reg, err := NewRegistry()
+		require.NoError(t, err)
+		var got any
+		require.NoError(t, reg.Unmarshal([]byte(`{"$test.ne": 1}`), &got))
+		assert.Equal(t, &NotEqual{expected: float64(1)}, got)
+	})
+
+	t.Run("custom namespace decode succeeds", func(t *testing.T) {
+		reg, err := NewRegistry(WithNamespace("te"))
+		require.NoError(t, err)
+		var got any
+		require.NoError(t, reg.Unmarshal([]byte(`{"$te.ne": 1}`), &got))
+		assert.Equal(t, &NotEqual{expected: float64(1)}, got)
+	})
+
+	t.Run("empty namespace decode succeeds", func(t *testing.T) {
+		reg, err := NewRegistry(WithNamespace(""))
+		require.NoError(t, err)
+		var got any
+		require.NoError(t, reg.Unmarshal([]byte(`{"$ne": 1}`), &got))
+		assert.Equal(t, &NotEqual{expected: float64(1)}, got)
+	})
+
+	t.Run("alias decode succeeds", func(t *testing.T) {
+		reg, err := NewRegistry(WithAlias("equals", "eq"))
+		require.NoError(t, err)
+		var got any
+		require.NoError(t, reg.Unmarshal([]byte(`{"$equals": 1}`), &got))
+		assert.Equal(t, &Equal{expected: float64(1)}, got)
+	})
+
+	t.Run("unknown alias target returns error", func(t *testing.T) {
+		reg, err := NewRegistry(WithAlias("equals", "nope"))
+		assert.Error(t, err)
+		assert.Nil(t, reg)
+	})
+}
+
+func TestRegisterBuiltins(t *testing.T) {
+	t.Run("duplicate registration returns error", func(t *testing.T) {
+		reg, err := jwalk.NewRegistry()
+		require.NoError(t, err)
+		require.NoError(t, RegisterBuiltins(reg))
+		assert.Error(t, RegisterBuiltins(reg))
+	})
+
+	t.Run("distinct namespaces coexist succeeds", func(t *testing.T) {
+		reg, err := jwalk.NewRegistry()
+		require.NoError(t, err)
+		require.NoError(t, RegisterBuiltins(reg, WithNamespace("a")))
+		require.NoError(t, RegisterBuiltins(reg, WithNamespace("b")))
+		var got any
+		require.NoError(t, reg.Unmarshal([]byte(`{"$b.ne": 1}`), &got))
+		assert.Equal(t, &NotEqual{expected: float64(1)}, got)
+	})
+}
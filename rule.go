@@ -1,26 +1,47 @@
 package testequals
 
+import "time"
+
 // Rule defines a pluggable comparison operator. Implementations receive the
 // active Tester so they may delegate nested comparisons using existing subset /
 // strict behavior. Return *MismatchError (single failure), *MultiError (many),
 // or nil on success. Any other error value is converted into a path‑aware
-// *MismatchError.
+// *MismatchError of kind RuleFailed that unwraps to the original error.
+// Returning a *MismatchError lets a rule fill in the structured fields (Kind,
+// Expected, Actual and Rule) itself.
 type Rule interface {
 	Test(rc *RuleContext, actual any) error
 }
 
+// AbsentRule is implemented by rules that can be evaluated against an object
+// key missing from the actual document. When an expected document entry holds
+// an AbsentRule and the key is absent, TestAbsent is called instead of
+// reporting "key not found". Return values follow the same conventions as
+// Rule.Test.
+type AbsentRule interface {
+	Rule
+	TestAbsent(rc *RuleContext) error
+}
+
 // RuleContext exposes a controlled surface of cmpCtx for rules. It permits
 // adding mismatches, temporary path segment pushes, and invoking nested
 // comparisons that inherit the current path and aggregation behavior.
 type RuleContext struct {
 	runner testRunner
 	inner  *cmpCtx
+	// depth is the length of inner.path when the rule was invoked; segments
+	// pushed beyond it prefix the mismatches returned by Test.
+	depth int
+}
+
+func newRuleContext(r testRunner, ctx *cmpCtx) *RuleContext {
+	return &RuleContext{runner: r, inner: ctx, depth: len(ctx.path)}
 }
 
 // Add records a mismatch at the current path. Returns the mismatch error when
 // aggregation is disabled so callers may bail out early; otherwise returns nil.
 func (rc *RuleContext) Add(msg string) error {
-	return rc.inner.report(mismatch(rc.inner.path, msg))
+	return rc.inner.report(&MismatchError{Path: append(Path{}, rc.inner.path...), Message: msg, Kind: RuleFailed})
 }
 
 // PushKey appends an object key to the path; the returned function must be
@@ -37,11 +58,113 @@ func (rc *RuleContext) PushIndex(i int) (pop func()) {
 	return func() { rc.inner.pop() }
 }
 
+// PushSegment appends seg to the path, e.g. a KeyedSegment; the returned
+// function must be deferred to restore the previous path.
+func (rc *RuleContext) PushSegment(seg PathSegment) (pop func()) {
+	rc.inner.push(seg)
+	return func() { rc.inner.pop() }
+}
+
 // Test performs a nested comparison using the shared context so any mismatches
-// are path‑aware and aggregated according to CollectAll.
-func (rc *RuleContext) Test(expected, actual any) error { return rc.runner.Test(expected, actual) }
+// are path‑aware and aggregated according to CollectAll. Mismatch paths are
+// relative to the rule's value and include any segments pushed with PushKey or
+// PushIndex.
+func (rc *RuleContext) Test(expected, actual any) error {
+	err := rc.runner.testNested(rc.inner, expected, actual)
+	rel := rc.inner.path[rc.depth:]
+	if err == nil || len(rel) == 0 {
+		return err
+	}
+	switch e := err.(type) {
+	case *MismatchError:
+		return e.at(rel)
+	case *MultiError:
+		ms := make([]*MismatchError, len(e.Mismatches))
+		for i, m := range e.Mismatches {
+			ms[i] = m.at(rel)
+		}
+		return &MultiError{Mismatches: ms}
+	default:
+		return err
+	}
+}
+
+// probe runs a fail-fast nested comparison that is never aggregated, for rules
+// that try several candidates (e.g. to match array elements) and only need to
+// know whether one matches. Its "$capture" bindings are discarded.
+func (rc *RuleContext) probe(expected, actual any) error {
+	_, err := rc.stagedProbe(expected, actual)
+	return err
+}
+
+// stagedProbe is like probe but returns the "$capture" bindings the comparison
+// staged, for the rule to commit with commitCaptures if it settles on the
+// candidate.
+func (rc *RuleContext) stagedProbe(expected, actual any) (*captureSet, error) {
+	c := *rc.inner
+	c.collect = false
+	c.captures = rc.inner.captures.child()
+	c.mismatches = nil
+	return c.captures, rc.runner.testNested(&c, expected, actual)
+}
+
+// diagnose runs a nested comparison that collects every mismatch, for rules
+// that explain a failure in detail. Paths are relative to the current value.
+func (rc *RuleContext) diagnose(expected, actual any) []*MismatchError {
+	c := *rc.inner
+	c.collect = true
+	c.captures = rc.inner.captures.child()
+	c.mismatches = nil
+	return mismatchesOf(rc.runner.testNested(&c, expected, actual))
+}
+
+// ignored reports whether the current value, or its descendant below segs, is
+// excluded from comparison by the Tester's ignored paths.
+func (rc *RuleContext) ignored(segs ...PathSegment) bool {
+	return rc.inner.ignored(segs...)
+}
+
+// withModes overrides the object and array modes, when non-zero, for the
+// nested comparisons run by Test; the returned function restores them.
+func (rc *RuleContext) withModes(objects ObjectMode, arrays ArrayMode) (restore func()) {
+	prevObjects, prevArrays := rc.inner.objects, rc.inner.arrays
+	if objects != 0 {
+		rc.inner.objects = objects
+	}
+	if arrays != 0 {
+		rc.inner.arrays = arrays
+	}
+	return func() { rc.inner.objects, rc.inner.arrays = prevObjects, prevArrays }
+}
+
+// pathRule returns the Tester path rule applying to the current value, or its
+// descendant below segs, if any.
+func (rc *RuleContext) pathRule(segs ...PathSegment) (Rule, bool) {
+	return rc.inner.pathRule(segs...)
+}
+
+// TestAbsent evaluates expected against an object key that is missing from the
+// actual document, which is distinct from a key that is present with a null
+// value. Expectations implementing AbsentRule decide the outcome; anything else
+// fails with ErrKeyMissing.
+func (rc *RuleContext) TestAbsent(expected any) error {
+	if ar, ok := expected.(AbsentRule); ok {
+		return ar.TestAbsent(rc)
+	}
+	return ruleMismatch("", KeyNotFound, expected, nil, "key not found: %w", ErrKeyMissing)
+}
+
+// Now returns the current time according to the Tester's clock (see
+// WithClock).
+func (rc *RuleContext) Now() time.Time {
+	if rc.inner.now == nil {
+		return time.Now()
+	}
+	return rc.inner.now()
+}
 
-// testRunner allows mocking Tester in unit tests.
+// testRunner allows mocking Tester in unit tests. testNested compares in a
+// context nested below parent.
 type testRunner interface {
-	Test(expected, actual any) error
+	testNested(parent *cmpCtx, expected, actual any) error
 }
@@ -1,33 +1,78 @@
 package testequals
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"maps"
+	"math"
+	"net/url"
 	"reflect"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/calumari/jwalk"
+	"github.com/go-json-experiment/json"
 )
 
+// The builtin directives, registered under the "test" namespace. Each is
+// declared once here; NewRegistry and RegisterBuiltins pick up every directive
+// declared with builtin.
 var (
-	TestEqualDirective              = jwalk.NewDirective("test.eq", unmarshalEqual)
-	TestNotEqualDirective           = jwalk.NewDirective("test.ne", unmarshalNotEqual)
-	TestNilDirective                = jwalk.NewDirective("test.nil", unmarshalNil(true))
-	TestRequiredDirective           = jwalk.NewDirective("test.required", unmarshalRequired(true))
-	TestAnyDirective                = jwalk.NewDirective("test.any", unmarshalAny)
-	TestMatchStringDirective        = jwalk.NewDirective("test.regex", unmarshalMatchString)
-	TestElementsMatchDirective      = jwalk.NewDirective("test.elementsMatch", unmarshalElementsMatch)
-	TestLengthDirective             = jwalk.NewDirective("test.length", unmarshalLength)
-	TestEmptyDirective              = jwalk.NewDirective("test.empty", unmarshalEmpty)
-	TestLessThanDirective           = jwalk.NewDirective("test.lt", unmarshalLT(false))
-	TestLessThanOrEqualDirective    = jwalk.NewDirective("test.lte", unmarshalLT(true))
-	TestGreaterThanDirective        = jwalk.NewDirective("test.gt", unmarshalGT(false))
-	TestGreaterThanOrEqualDirective = jwalk.NewDirective("test.gte", unmarshalGT(true))
-	TestInDirective                 = jwalk.NewDirective("test.in", unmarshalIn)
-	TestAndDirective                = jwalk.NewDirective("test.and", unmarshalAnd)
-	TestOrDirective                 = jwalk.NewDirective("test.or", unmarshalOr)
-	TestNorDirective                = jwalk.NewDirective("test.nor", unmarshalNor)
-	TestNotDirective                = jwalk.NewDirective("test.not", unmarshalNot)
+	TestEqualDirective              = builtin("eq", unmarshalEqual)
+	TestNotEqualDirective           = builtin("ne", unmarshalNotEqual)
+	TestNilDirective                = builtin("nil", unmarshalNil(true))
+	TestRequiredDirective           = builtin("required", unmarshalRequired(true))
+	TestAnyDirective                = builtin("any", unmarshalAny)
+	TestMatchStringDirective        = builtin("regex", unmarshalMatchString)
+	TestElementsMatchDirective      = builtin("elementsMatch", unmarshalElementsMatch)
+	TestLengthDirective             = builtin("length", unmarshalLength)
+	TestEmptyDirective              = builtin("empty", unmarshalEmpty)
+	TestLessThanDirective           = builtin("lt", unmarshalLT(false))
+	TestLessThanOrEqualDirective    = builtin("lte", unmarshalLT(true))
+	TestGreaterThanDirective        = builtin("gt", unmarshalGT(false))
+	TestGreaterThanOrEqualDirective = builtin("gte", unmarshalGT(true))
+	TestInDirective                 = builtin("in", unmarshalIn)
+	TestAndDirective                = builtin("and", unmarshalAnd)
+	TestOrDirective                 = builtin("or", unmarshalOr)
+	TestNorDirective                = builtin("nor", unmarshalNor)
+	TestNotDirective                = builtin("not", unmarshalNot)
+	TestExistsDirective             = builtin("exists", unmarshalExists(true))
+	TestAbsentDirective             = builtin("absent", unmarshalExists(false))
+	TestOptionalDirective           = builtin("optional", unmarshalOptional)
+	TestSubsetDirective             = builtin("subset", unmarshalSubset)
+	TestStrictDirective             = builtin("strict", unmarshalStrict)
+	TestModeDirective               = builtin("mode", unmarshalMode)
+	TestContainsDirective           = builtin("contains", unmarshalContains)
+	TestContainsInOrderDirective    = builtin("containsInOrder", unmarshalContainsInOrder)
+	TestKeyedByDirective            = builtin("keyedBy", unmarshalKeyedBy)
+	TestEachDirective               = builtin("each", unmarshalEach)
+	TestSomeDirective               = builtin("some", unmarshalSome)
+	TestCountDirective              = builtin("count", unmarshalCount)
+	TestTypeDirective               = builtin("type", unmarshalType)
+	TestFormatDirective             = builtin("format", unmarshalFormat)
+	TestAfterDirective              = builtin("after", unmarshalTime("after"))
+	TestBeforeDirective             = builtin("before", unmarshalTime("before"))
+	TestTimeEqualDirective          = builtin("timeEq", unmarshalTime("timeEq"))
+	TestWithinDirective             = builtin("within", unmarshalWithin)
+	TestApproxDirective             = builtin("approx", unmarshalApprox)
+	TestStartsWithDirective         = builtin("startsWith", unmarshalStringCompare("startsWith"))
+	TestEndsWithDirective           = builtin("endsWith", unmarshalStringCompare("endsWith"))
+	TestContainsStringDirective     = builtin("containsString", unmarshalStringCompare("containsString"))
+	TestEqualFoldDirective          = builtin("ieq", unmarshalStringCompare("ieq"))
+	TestParseJSONDirective          = builtin("parseJSON", unmarshalParseJSON)
+	TestBase64Directive             = builtin("base64", unmarshalBase64)
+	TestJWTDirective                = builtin("jwt", unmarshalJWT)
+	TestURLDirective                = builtin("url", unmarshalURL)
+	TestCaptureDirective            = builtin("capture", unmarshalCapture)
+	TestRefDirective                = builtin("ref", unmarshalRef)
+	TestDefsDirective               = builtin("defs", unmarshalDefs)
+	TestUseDirective                = builtin("use", unmarshalUse)
 )
 
 // Equal is a Rule that enforces strict deep equality (including object key
@@ -42,39 +87,61 @@ func (c *Equal) Test(rc *RuleContext, actual any) error {
 	// simply call tester.Test(expected, actual) because Tester intentionally
 	// applies subset semantics for documents. Instead we perform our own
 	// document key set check, then use tester.Test for each value so nested
-	// directives behave normally.
+	// directives behave normally. The Tester's object and array modes are
+	// pinned to strict so a global mode cannot loosen the subtree.
+	defer rc.withModes(ObjectStrict, ArrayStrict)()
 	switch exp := c.expected.(type) {
 	case jwalk.Document:
-		act, ok := actual.(jwalk.Document)
+		act, ok := asDocument(actual)
 		if !ok {
-			return mismatch(nil, fmt.Sprintf("expected jwalk.Document, got %T", actual))
+			return ruleMismatch("$eq", TypeMismatch, exp, actual, "expected jwalk.Document, got %T", actual)
 		}
+		// Keys governed by Tester path rules are part of the expected set.
+		exp = rc.inner.withPathRules(exp, act)
 		// Build map of actual values for O(1) lookup and to detect extras.
 		amap := make(map[string]any, len(act))
 		for _, e := range act {
 			amap[e.Key] = e.Value
 		}
 		for _, e := range exp {
+			if rc.ignored(keySeg(e.Key)) {
+				delete(amap, e.Key)
+				continue
+			}
 			av, ok := amap[e.Key]
 			if !ok {
-				return mismatch([]string{keySeg(e.Key)}, "key not found")
+				ev := e.Value
+				if r, ruled := rc.pathRule(keySeg(e.Key)); ruled {
+					ev = r
+				}
+				ar, absentOK := ev.(AbsentRule)
+				if !absentOK {
+					return ruleMismatch("$eq", KeyNotFound, ev, nil, "key not found").at(Path{keySeg(e.Key)})
+				}
+				if err := ar.TestAbsent(rc); err != nil {
+					return mismatchesOf(err)[0].at(Path{keySeg(e.Key)})
+				}
+				continue
 			}
 			// Compare the expected value against the actual using Tester semantics.
-			if err := rc.Test(e.Value, av); err != nil {
-				// err may be *MismatchError or *MultiError; Tester.Test already formats paths.
+			pop := rc.PushKey(e.Key)
+			err := rc.Test(e.Value, av)
+			pop()
+			if err != nil {
+				// err may be *MismatchError or *MultiError, relative to this rule.
 				return err
 			}
 			delete(amap, e.Key)
 		}
-		if len(amap) > 0 {
-			// Report first extra key.
-			for k := range amap { // deterministic enough for error reporting
-				return mismatch([]string{keySeg(k)}, fmt.Sprintf("unexpected extra key %q (strict $eq)", k))
+		// Report the first extra key in actual order.
+		for _, e := range act {
+			if _, extra := amap[e.Key]; extra && !rc.ignored(keySeg(e.Key)) {
+				return ruleMismatch("$eq", UnexpectedKey, nil, e.Value, "unexpected extra key %q (strict $eq)", e.Key).at(Path{keySeg(e.Key)})
 			}
 		}
 		return nil
 	case jwalk.Array:
-		// Arrays already strict in Tester, delegate.
+		// Arrays are strict under the pinned mode, delegate.
 		return rc.Test(exp, actual)
 	default:
 		// Primitive or directive-containing value: rely on Tester for deep equality.
@@ -108,9 +175,9 @@ func (c *Nil) Test(rc *RuleContext, actual any) error {
 	if !c.wanted {
 		if isNil == c.expected {
 			if isNil {
-				return fmt.Errorf("value is nil but expectation not explicitly asserted ($nil true required): %w", ErrImplicitNil)
+				return ruleMismatch("$nil", RuleFailed, c.expected, actual, "value is nil but expectation not explicitly asserted ($nil true required): %w", ErrImplicitNil)
 			}
-			return fmt.Errorf("value is non-nil but expectation not explicitly asserted ($nil false required): %w", ErrImplicitNotNil)
+			return ruleMismatch("$nil", RuleFailed, c.expected, actual, "value is non-nil but expectation not explicitly asserted ($nil false required): %w", ErrImplicitNotNil)
 		}
 		return nil
 	}
@@ -119,9 +186,9 @@ func (c *Nil) Test(rc *RuleContext, actual any) error {
 	// Enforce strictly; mismatch is an error.
 	if isNil != c.expected {
 		if c.expected {
-			return fmt.Errorf("expected nil ($nil true), got non-nil (%T): %w", actual, ErrNil)
+			return ruleMismatch("$nil", RuleFailed, c.expected, actual, "expected nil ($nil true), got non-nil (%T): %w", actual, ErrNil)
 		}
-		return fmt.Errorf("expected non-nil ($nil false), got nil: %w", ErrNotNil)
+		return ruleMismatch("$nil", RuleFailed, c.expected, actual, "expected non-nil ($nil false), got nil: %w", ErrNotNil)
 	}
 
 	return nil
@@ -137,7 +204,7 @@ func (c *Required) Test(rc *RuleContext, actual any) error {
 	}
 	v := reflect.ValueOf(actual)
 	if isNil(v) || isZero(v) {
-		return fmt.Errorf("required value missing or zero (%T): %w", actual, ErrRequired)
+		return ruleMismatch("$required", RuleFailed, c.want, actual, "required value missing or zero (%T): %w", actual, ErrRequired)
 	}
 	return nil
 }
@@ -159,12 +226,12 @@ func AnyDirective(name string) *jwalk.Directive {
 type MatchString struct{ re *regexp.Regexp }
 
 func (c *MatchString) Test(rc *RuleContext, actual any) error {
-	s, ok := actual.(string)
+	s, ok := asText(actual)
 	if !ok {
-		return fmt.Errorf("$regex expects string, got %T", actual)
+		return ruleMismatch("$regex", TypeMismatch, c.re.String(), actual, "$regex expects string, got %T", actual)
 	}
 	if !c.re.MatchString(s) {
-		return fmt.Errorf("string %q does not match pattern %q", s, c.re.String())
+		return ruleMismatch("$regex", RuleFailed, c.re.String(), s, "string %q does not match pattern %q", s, c.re.String())
 	}
 	return nil
 }
@@ -173,33 +240,119 @@ func MatchStringDirective(name string) *jwalk.Directive {
 	return jwalk.NewDirective(name, unmarshalMatchString)
 }
 
+// ElementsMatch requires the actual array to be a permutation of the expected
+// elements, each matching a distinct actual element under Tester semantics. The
+// pairing is a maximum bipartite matching, so loose expectations that match
+// several elements cannot cause spurious failures.
 type ElementsMatch struct{ expected []any }
 
 func (c *ElementsMatch) Test(rc *RuleContext, actual any) error {
-	av := reflect.ValueOf(actual)
-	if !isList(av) {
-		return fmt.Errorf("$elementsMatch expects array/slice, got %T", actual)
+	act, ok := asArray(actual)
+	if !ok {
+		return ruleMismatch("$elementsMatch", TypeMismatch, c.expected, actual, "$elementsMatch expects array/slice, got %T", actual)
 	}
-	al := av.Len()
-	if al != len(c.expected) {
-		return fmt.Errorf("$elementsMatch length mismatch: expected %d elements, got %d", len(c.expected), al)
-	}
-	used := make([]bool, al)
-	for _, exp := range c.expected {
-		matched := false
-		for i := range al {
-			if used[i] {
+	if len(act) != len(c.expected) {
+		return ruleMismatch("$elementsMatch", LengthMismatch, len(c.expected), len(act), "$elementsMatch length mismatch: expected %d elements, got %d", len(c.expected), len(act))
+	}
+	matched, err := matchArray(rc, c.expected, act)
+	if err != nil {
+		return err
+	}
+	return reportUnmatched(rc, "$elementsMatch", c.expected, act, matched)
+}
+
+// matchArray pairs expected with distinct actual elements for the unordered
+// array rules (see matchElements). Arrays of primitives are matched by
+// multiset counting instead of comparing every pair. When every expected
+// element is paired, the "$capture" bindings of the chosen pairs are kept.
+func matchArray(rc *RuleContext, expected, actual []any) ([]int, error) {
+	if rc.inner.primitivesExact() {
+		if matched, ok := matchPrimitives(expected, actual); ok {
+			return matched, nil
+		}
+	}
+	staged := make(map[[2]int]*captureSet)
+	matched := matchElements(len(expected), len(actual), func(ei, ai int) error {
+		defer rc.PushIndex(ai)()
+		caps, err := rc.stagedProbe(expected[ei], actual[ai])
+		staged[[2]int{ei, ai}] = caps
+		return err
+	})
+	if slices.Contains(matched, -1) {
+		return matched, nil
+	}
+	for ei, ai := range matched {
+		pop := rc.PushIndex(ai)
+		err := rc.commitCaptures(staged[[2]int{ei, ai}])
+		pop()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return matched, nil
+}
+
+// matchAt reports whether the actual element at index i matches expected and,
+// if it does, keeps the "$capture" bindings made comparing them.
+func matchAt(rc *RuleContext, i int, expected, actual any) (bool, error) {
+	defer rc.PushIndex(i)()
+	staged, err := rc.stagedProbe(expected, actual)
+	if err != nil {
+		return false, nil
+	}
+	return true, rc.commitCaptures(staged)
+}
+
+// reportUnmatched reports the expected elements left unmatched by
+// matchElements. Each is paired with its closest remaining actual element, the
+// one with the fewest nested mismatches, whose mismatches are returned as the
+// report's Err (a *MultiError, paths starting at the candidate's index).
+func reportUnmatched(rc *RuleContext, rule string, expected, actual []any, matched []int) error {
+	used := make([]bool, len(actual))
+	for _, ai := range matched {
+		if ai >= 0 {
+			used[ai] = true
+		}
+	}
+	var failed []*MismatchError
+	for ei, ai := range matched {
+		if ai >= 0 {
+			continue
+		}
+		exp := expected[ei]
+		best, bestMs := -1, []*MismatchError(nil)
+		for ai := range actual {
+			if used[ai] {
 				continue
 			}
-			if err := rc.Test(exp, av.Index(i).Interface()); err == nil {
-				used[i] = true
-				matched = true
-				break
+			pop := rc.PushIndex(ai)
+			ms := rc.diagnose(exp, actual[ai])
+			pop()
+			if best < 0 || len(ms) < len(bestMs) {
+				best, bestMs = ai, ms
+			}
+			if len(bestMs) <= 1 {
+				break // no candidate can be closer
 			}
 		}
-		if !matched {
-			return fmt.Errorf("$elementsMatch could not find match for expected element %v", exp)
+		var m *MismatchError
+		if best < 0 {
+			m = ruleMismatch(rule, RuleFailed, exp, actual, "%s could not find match for expected element %v", rule, exp)
+		} else {
+			used[best] = true
+			nested := make([]*MismatchError, len(bestMs))
+			for i, nm := range bestMs {
+				nested[i] = nm.at(Path{indexSeg(best)})
+			}
+			m = ruleMismatch(rule, RuleFailed, exp, actual[best], "%s could not find match for expected element %v; closest candidate is [%d]: %w", rule, exp, best, &MultiError{Mismatches: nested})
+		}
+		if !rc.inner.collect {
+			return m
 		}
+		failed = append(failed, m)
+	}
+	if len(failed) > 0 {
+		return &MultiError{Mismatches: failed}
 	}
 	return nil
 }
@@ -210,34 +363,51 @@ type Length struct {
 	lte *int
 	gt  *int
 	gte *int
+	// expected, when set, is compared against the length using Tester
+	// semantics (e.g. a number or a numeric comparator rule).
+	expected any
 }
 
 func (c *Length) Test(rc *RuleContext, actual any) error {
-	av := reflect.ValueOf(actual)
+	av := derefValue(reflect.ValueOf(actual))
 	if !isList(av) {
-		return fmt.Errorf("$length expects array/slice, got %T", actual)
+		return ruleMismatch("$length", TypeMismatch, nil, actual, "$length expects array/slice, got %T", actual)
 	}
 	al := av.Len()
 
-	if c.eq != nil && al != *c.eq {
-		return fmt.Errorf("$length eq failed: got %d, expected == %d", al, *c.eq)
-	}
-	if c.lt != nil && (al >= *c.lt) {
-		return fmt.Errorf("$length lt failed: got %d, expected < %d", al, *c.lt)
-	}
-	if c.lte != nil && (al > *c.lte) {
-		return fmt.Errorf("$length lte failed: got %d, expected <= %d", al, *c.lte)
-	}
-	if c.gt != nil && (al <= *c.gt) {
-		return fmt.Errorf("$length gt failed: got %d, expected > %d", al, *c.gt)
+	if c.expected != nil {
+		if err := rc.Test(c.expected, al); err != nil {
+			return ruleMismatch("$length", LengthMismatch, c.expected, al, "$length failed: %w", err)
+		}
 	}
-	if c.gte != nil && (al < *c.gte) {
-		return fmt.Errorf("$length gte failed: got %d, expected >= %d", al, *c.gte)
+
+	if op, bound, failed := checkBounds(al, c.eq, c.lt, c.lte, c.gt, c.gte); failed {
+		return ruleMismatch("$length", LengthMismatch, bound, al, "$length %s failed: got %d, expected %s %d", op, al, boundSymbols[op], bound)
 	}
 
 	return nil
 }
 
+var boundSymbols = map[string]string{"eq": "==", "lt": "<", "lte": "<=", "gt": ">", "gte": ">="}
+
+// checkBounds reports the first of the optional bounds shared by $length and
+// $count that n violates, by operator name and bound value.
+func checkBounds(n int, eq, lt, lte, gt, gte *int) (op string, bound int, failed bool) {
+	switch {
+	case eq != nil && n != *eq:
+		return "eq", *eq, true
+	case lt != nil && n >= *lt:
+		return "lt", *lt, true
+	case lte != nil && n > *lte:
+		return "lte", *lte, true
+	case gt != nil && n <= *gt:
+		return "gt", *gt, true
+	case gte != nil && n < *gte:
+		return "gte", *gte, true
+	}
+	return "", 0, false
+}
+
 type Empty struct{ want bool }
 
 // Adjust Empty.Test semantics:
@@ -248,29 +418,39 @@ func (c *Empty) Test(rc *RuleContext, actual any) error {
 	empty := isEmpty(av)
 	if c.want {
 		if !empty {
-			return fmt.Errorf("$empty true failed: value (%T)%v not empty", actual, actual)
+			return ruleMismatch("$empty", RuleFailed, c.want, actual, "$empty true failed: value (%T)%v not empty", actual, actual)
 		}
 	} else {
 		if empty {
-			return fmt.Errorf("$empty false failed: value (%T)%v is empty", actual, actual)
+			return ruleMismatch("$empty", RuleFailed, c.want, actual, "$empty false failed: value (%T)%v is empty", actual, actual)
 		}
 	}
 	return nil
 }
 
-// NotEqual fails if actual deeply equals the expected value.
+// NotEqual fails if actual deeply equals the expected value, or for numbers
+// is within the Tester's float tolerance of it.
 type NotEqual struct{ expected any }
 
 func (c *NotEqual) Test(rc *RuleContext, actual any) error {
+	// Normalize actual like $eq does for primitive expected values.
+	actual = leafValue(c.expected, actual)
 	handled, err := fastPrimitiveEqual(c.expected, actual)
+	// Numbers within the Tester's float tolerance are equal for $eq, so
+	// they are equal here too.
+	if err != nil && rc.inner.floats != (tolerance{}) {
+		if ok, terr := numericClose(c.expected, actual, rc.inner.floats); ok {
+			handled, err = true, terr
+		}
+	}
 	if err == nil {
-		return fmt.Errorf("$ne failed: values are equal (%v)", c.expected)
+		return ruleMismatch("$ne", RuleFailed, c.expected, actual, "$ne failed: values are equal (%v)", c.expected)
 	}
 	if handled {
 		return nil
 	}
 	if reflect.DeepEqual(c.expected, actual) {
-		return fmt.Errorf("$ne failed: values are deeply equal (%v)", c.expected)
+		return ruleMismatch("$ne", RuleFailed, c.expected, actual, "$ne failed: values are deeply equal (%v)", c.expected)
 	}
 	return nil
 }
@@ -283,40 +463,49 @@ type numericCompare struct {
 }
 
 func (c *numericCompare) Test(rc *RuleContext, actual any) error {
-	val, ok := toFloat64(actual)
+	val, ok := toFloat64(plainValue(reflect.ValueOf(actual)))
 	if !ok {
-		return fmt.Errorf("$%s expects numeric value, got %T", c.op, actual)
+		return ruleMismatch(c.name(), TypeMismatch, c.ref, actual, "$%s expects numeric value, got %T", c.op, actual)
 	}
 	switch c.op {
 	case "lt":
 		if !(val < c.ref || (c.incl && val == c.ref)) {
 			if c.incl {
-				return fmt.Errorf("$lte failed: got %v, expected <= %v", trimFloat(val), trimFloat(c.ref))
+				return ruleMismatch("$lte", RuleFailed, c.ref, actual, "$lte failed: got %v, expected <= %v", trimFloat(val), trimFloat(c.ref))
 			}
-			return fmt.Errorf("$lt failed: got %v, expected < %v", trimFloat(val), trimFloat(c.ref))
+			return ruleMismatch("$lt", RuleFailed, c.ref, actual, "$lt failed: got %v, expected < %v", trimFloat(val), trimFloat(c.ref))
 		}
 	case "gt":
 		if !(val > c.ref || (c.incl && val == c.ref)) {
 			if c.incl {
-				return fmt.Errorf("$gte failed: got %v, expected >= %v", trimFloat(val), trimFloat(c.ref))
+				return ruleMismatch("$gte", RuleFailed, c.ref, actual, "$gte failed: got %v, expected >= %v", trimFloat(val), trimFloat(c.ref))
 			}
-			return fmt.Errorf("$gt failed: got %v, expected > %v", trimFloat(val), trimFloat(c.ref))
+			return ruleMismatch("$gt", RuleFailed, c.ref, actual, "$gt failed: got %v, expected > %v", trimFloat(val), trimFloat(c.ref))
 		}
 	default:
-		return errors.New("unknown numeric comparator")
+		return ruleMismatch(c.name(), RuleFailed, c.ref, actual, "unknown numeric comparator")
 	}
 	return nil
 }
 
+// name returns the directive name of the comparator (e.g. "$lte").
+func (c *numericCompare) name() string {
+	if c.incl {
+		return "$" + c.op + "e"
+	}
+	return "$" + c.op
+}
+
 type InSet struct{ elems []any }
 
 func (c *InSet) Test(rc *RuleContext, actual any) error {
 	for _, e := range c.elems {
-		if err := rc.Test(e, actual); err == nil {
-			return nil
+		staged, err := rc.staged(func() error { return rc.Test(e, actual) })
+		if err == nil {
+			return rc.commitCaptures(staged)
 		}
 	}
-	return fmt.Errorf("$in failed: value %v not in %v", actual, c.elems)
+	return ruleMismatch("$in", RuleFailed, c.elems, actual, "$in failed: value %v not in %v", actual, c.elems)
 }
 
 type And struct{ rules []any }
@@ -328,80 +517,1202 @@ type Nor struct{ rules []any }
 type Not struct{ rule any }
 
 func (c *And) Test(rc *RuleContext, actual any) error {
-	// $and requires all rules to pass. We can reuse the parent context because
-	// failures always invalidate the whole operator; when collecting we append
-	// each rule's mismatches directly.
+	// $and requires all rules to pass. Without aggregation the first failure
+	// invalidates the whole operator; when collecting, every failing rule's
+	// mismatches are returned.
+	var failed []*MismatchError
 	for _, r := range c.rules {
 		if err := rc.Test(r, actual); err != nil {
 			if !rc.inner.collect {
-				return fmt.Errorf("$and failed: %w", err)
+				return ruleMismatch("$and", RuleFailed, c.rules, actual, "$and failed: %w", err)
 			}
+			failed = append(failed, mismatchesOf(err)...)
 		}
 	}
-	if rc.inner.collect && len(rc.inner.mismatches) > 0 {
-		return &MultiError{Mismatches: rc.inner.mismatches}
+	if len(failed) > 0 {
+		return &MultiError{Mismatches: failed}
 	}
 	return nil
 }
 
 func (c *Or) Test(rc *RuleContext, actual any) error {
-	// $or succeeds if any rule passes. To avoid polluting the parent mismatch
-	// list when one eventually passes, we evaluate each alternative in an
-	// isolated child context and only merge on total failure.
+	// $or succeeds if any rule passes. Nested comparisons run in isolated
+	// contexts, so failures of earlier alternatives are only surfaced when every
+	// alternative fails, and only the matching alternative's captures are kept.
+	if len(c.rules) == 0 {
+		return ruleMismatch("$or", RuleFailed, c.rules, actual, "$or failed: no alternatives provided")
+	}
 	var firstErr error
-	var failedChildren []*cmpCtx
+	var failed []*MismatchError
 	for _, r := range c.rules {
-		childCtx := &cmpCtx{path: append([]string{}, rc.inner.path...), collect: rc.inner.collect}
-		child := &RuleContext{runner: rc.runner, inner: childCtx}
-		if err := child.Test(r, actual); err == nil {
-			return nil // success, discard prior failures
-		} else {
-			if firstErr == nil {
-				firstErr = err
-			}
-			failedChildren = append(failedChildren, childCtx)
+		staged, err := rc.staged(func() error { return rc.Test(r, actual) })
+		if err == nil {
+			return rc.commitCaptures(staged) // success, discard prior failures
+		}
+		if firstErr == nil {
+			firstErr = err
 		}
+		failed = append(failed, mismatchesOf(err)...)
 	}
 	// All failed
 	if rc.inner.collect {
-		if len(failedChildren) == 0 {
-			return errors.New("$or failed: no alternatives provided")
-		}
-		for _, ch := range failedChildren {
-			rc.inner.mismatches = append(rc.inner.mismatches, ch.mismatches...)
-		}
-		return &MultiError{Mismatches: rc.inner.mismatches}
-	}
-	if firstErr == nil {
-		return errors.New("$or failed: no alternatives provided")
+		return &MultiError{Mismatches: failed}
 	}
-	return fmt.Errorf("$or failed: value did not satisfy any alternative; first error: %v", firstErr)
+	return ruleMismatch("$or", RuleFailed, c.rules, actual, "$or failed: value did not satisfy any alternative; first error: %w", firstErr)
 }
 
 func (c *Nor) Test(rc *RuleContext, actual any) error {
 	// $nor fails if any rule succeeds. We can short‑circuit immediately in
 	// non‑collect mode. In collect mode we note all matching alternatives.
-	matchedIdx := []int{}
+	// Captures made by the alternatives are discarded.
+	var matched []*MismatchError
 	for i, r := range c.rules {
-		if err := rc.Test(r, actual); err == nil {
+		if _, err := rc.staged(func() error { return rc.Test(r, actual) }); err == nil {
 			if !rc.inner.collect {
-				return errors.New("$nor failed: value satisfied a forbidden alternative")
+				return ruleMismatch("$nor", RuleFailed, r, actual, "$nor failed: value satisfied a forbidden alternative")
 			}
-			matchedIdx = append(matchedIdx, i)
+			matched = append(matched, ruleMismatch("$nor", RuleFailed, r, actual, "$nor failed: alternative %d matched", i))
 		}
 	}
-	if rc.inner.collect && len(matchedIdx) > 0 {
-		for _, i := range matchedIdx {
-			rc.inner.mismatches = append(rc.inner.mismatches, mismatch(nil, fmt.Sprintf("$nor failed: alternative %d matched", i)))
-		}
-		return &MultiError{Mismatches: rc.inner.mismatches}
+	if len(matched) > 0 {
+		return &MultiError{Mismatches: matched}
 	}
 	return nil
 }
 
 func (c *Not) Test(rc *RuleContext, actual any) error {
-	if err := rc.Test(c.rule, actual); err == nil {
-		return errors.New("$not failed: value matched negated condition")
+	// Captures made by the negated rule are discarded.
+	if _, err := rc.staged(func() error { return rc.Test(c.rule, actual) }); err == nil {
+		return ruleMismatch("$not", RuleFailed, c.rule, actual, "$not failed: value matched negated condition")
+	}
+	return nil
+}
+
+// The logical operators implement AbsentRule by evaluating their operands
+// against the missing key. An operand that does not itself handle absence
+// fails with ErrKeyMissing, so $not and $nor only accept a missing key when
+// negating an operand that explicitly speaks to absence (e.g. $exists).
+
+func (c *And) TestAbsent(rc *RuleContext) error {
+	for _, r := range c.rules {
+		if err := rc.TestAbsent(r); err != nil {
+			return ruleMismatch("$and", mismatchesOf(err)[0].Kind, c.rules, nil, "$and failed: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *Or) TestAbsent(rc *RuleContext) error {
+	var firstErr error
+	for _, r := range c.rules {
+		err := rc.TestAbsent(r)
+		if err == nil {
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		return ruleMismatch("$or", RuleFailed, c.rules, nil, "$or failed: no alternatives provided")
+	}
+	return ruleMismatch("$or", mismatchesOf(firstErr)[0].Kind, c.rules, nil, "$or failed: missing key did not satisfy any alternative; first error: %w", firstErr)
+}
+
+func (c *Nor) TestAbsent(rc *RuleContext) error {
+	for _, r := range c.rules {
+		if _, ok := r.(AbsentRule); !ok {
+			return rc.TestAbsent(r)
+		}
+		if err := rc.TestAbsent(r); err == nil {
+			return ruleMismatch("$nor", RuleFailed, r, nil, "$nor failed: missing key satisfied a forbidden alternative")
+		}
 	}
 	return nil
 }
+
+func (c *Not) TestAbsent(rc *RuleContext) error {
+	if _, ok := c.rule.(AbsentRule); !ok {
+		return rc.TestAbsent(c.rule)
+	}
+	if err := rc.TestAbsent(c.rule); err == nil {
+		return ruleMismatch("$not", RuleFailed, c.rule, nil, "$not failed: missing key matched negated condition")
+	}
+	return nil
+}
+
+var (
+	ErrKeyPresent = errors.New("key present")
+	ErrKeyMissing = errors.New("key missing")
+)
+
+// Exists asserts the presence (want == true) or absence (want == false) of an
+// object key. It implements AbsentRule so it is evaluated even when the key is
+// missing from the actual document; any present value, including null,
+// satisfies presence.
+type Exists struct{ want bool }
+
+func (c *Exists) Test(rc *RuleContext, actual any) error {
+	if !c.want {
+		return ruleMismatch("$exists", UnexpectedKey, c.want, actual, "expected key to be absent ($exists false), got (%T)%v: %w", actual, actual, ErrKeyPresent)
+	}
+	return nil
+}
+
+func (c *Exists) TestAbsent(rc *RuleContext) error {
+	if c.want {
+		return ruleMismatch("$exists", KeyNotFound, c.want, nil, "key not found ($exists true): %w", ErrKeyMissing)
+	}
+	return nil
+}
+
+// Optional matches when the key is missing from the actual document and
+// otherwise requires the present value (including null) to satisfy expected.
+type Optional struct{ expected any }
+
+func (c *Optional) Test(rc *RuleContext, actual any) error {
+	return rc.Test(c.expected, actual)
+}
+
+func (c *Optional) TestAbsent(rc *RuleContext) error {
+	return nil
+}
+
+// Mode compares its expected value with the object and array comparison modes
+// overridden for the whole subtree; a zero mode keeps the inherited one. It
+// backs the "$subset", "$strict" and "$mode" directives.
+type Mode struct {
+	objects  ObjectMode
+	arrays   ArrayMode
+	expected any
+}
+
+func (c *Mode) Test(rc *RuleContext, actual any) error {
+	defer rc.withModes(c.objects, c.arrays)()
+	return rc.Test(c.expected, actual)
+}
+
+func (c *Mode) TestAbsent(rc *RuleContext) error {
+	return rc.TestAbsent(c.expected)
+}
+
+// ArrayContains passes when every expected element matches a distinct element
+// of the actual array, in any order; extra actual elements are allowed.
+// Elements are compared with Tester semantics, so documents match as subsets.
+type ArrayContains struct{ expected []any }
+
+func (c *ArrayContains) Test(rc *RuleContext, actual any) error {
+	act, ok := asArray(actual)
+	if !ok {
+		return ruleMismatch("$contains", TypeMismatch, c.expected, actual, "$contains expects array/slice, got %T", actual)
+	}
+	matched, err := matchArray(rc, c.expected, act)
+	if err != nil {
+		return err
+	}
+	return reportUnmatched(rc, "$contains", c.expected, act, matched)
+}
+
+// ArrayContainsInOrder passes when the expected elements match elements of the
+// actual array in the same relative order; other actual elements may be
+// interleaved. Each expected element tries the earliest matching actual element
+// after the previous match first. When a later element cannot be placed, the
+// search backtracks to elements whose match bound "$capture" values and tries
+// their next candidate, so a match is found whenever one exists. The captures
+// of the chosen matches are kept.
+type ArrayContainsInOrder struct{ expected []any }
+
+func (c *ArrayContainsInOrder) Test(rc *RuleContext, actual any) error {
+	act, ok := asArray(actual)
+	if !ok {
+		return ruleMismatch("$containsInOrder", TypeMismatch, c.expected, actual, "$containsInOrder expects array/slice, got %T", actual)
+	}
+	unplaced := 0
+	var place func(ei, from int) (bool, error)
+	place = func(ei, from int) (bool, error) {
+		if ei == len(c.expected) {
+			return true, nil
+		}
+		unplaced = max(unplaced, ei)
+		for ai := from; ai < len(act); ai++ {
+			matched, rest := false, false
+			staged, err := rc.staged(func() error {
+				var err error
+				if matched, err = matchAt(rc, ai, c.expected[ei], act[ai]); err != nil || !matched {
+					return err
+				}
+				rest, err = place(ei+1, ai+1)
+				return err
+			})
+			if err != nil {
+				return false, err
+			}
+			if rest {
+				return true, rc.commitCaptures(staged)
+			}
+			if matched && len(staged.bound) == 0 {
+				// A later candidate without bindings leaves the rest of the
+				// search no better off than this one did.
+				break
+			}
+		}
+		return false, nil
+	}
+	found, err := place(0, 0)
+	if err != nil || found {
+		return err
+	}
+	exp := c.expected[unplaced]
+	return ruleMismatch("$containsInOrder", RuleFailed, exp, actual, "$containsInOrder could not find match for expected element %d (%v) in order", unplaced, exp)
+}
+
+// KeyedArray compares an array of objects as a map keyed by the values of one
+// or more identity fields: each expected item is compared with the actual
+// element holding the same key values, in any order. Mismatches are reported
+// below keyed path segments such as .users[id=42].email, expected items with
+// no actual counterpart as KeyNotFound and, unless extra elements are allowed,
+// actual elements with no expected counterpart as UnexpectedKey.
+type KeyedArray struct {
+	keys       []string
+	items      []any
+	allowExtra bool
+}
+
+// AllowExtra permits actual elements without an expected counterpart, like
+// "allowExtra": true.
+func (c *KeyedArray) AllowExtra() *KeyedArray {
+	c.allowExtra = true
+	return c
+}
+
+// validate checks that every expected item is a document holding literal
+// primitive values for all key fields.
+func (c *KeyedArray) validate() error {
+	if len(c.keys) == 0 {
+		return errors.New("$keyedBy requires at least one key field")
+	}
+	seen := make(map[string]int, len(c.items))
+	for i, item := range c.items {
+		doc, ok := item.(jwalk.Document)
+		if !ok {
+			return fmt.Errorf("$keyedBy item %d must be an object, got %T", i, item)
+		}
+		_, id, err := c.identify(doc)
+		if err != nil {
+			return fmt.Errorf("$keyedBy item %d: %w", i, err)
+		}
+		if prev, dup := seen[id]; dup {
+			return fmt.Errorf("$keyedBy items %d and %d share the same key", prev, i)
+		}
+		seen[id] = i
+	}
+	return nil
+}
+
+// identify returns the key field values of doc along with a string that is
+// equal for two documents exactly when their key values are equal under
+// fastPrimitiveEqual (see primitiveKeyOf).
+func (c *KeyedArray) identify(doc jwalk.Document) ([]KeyMatch, string, error) {
+	terms := make([]KeyMatch, 0, len(c.keys))
+	var id strings.Builder
+	for _, k := range c.keys {
+		i := slices.IndexFunc(doc, func(e jwalk.Entry) bool { return e.Key == k })
+		if i < 0 {
+			return nil, "", fmt.Errorf("missing key field %q", k)
+		}
+		v := plainValue(reflect.ValueOf(doc[i].Value))
+		pk, ok := primitiveKeyOf(v)
+		if !ok || pk.f != pk.f {
+			return nil, "", fmt.Errorf("key field %q must be a string, number, boolean or null, got %T", k, doc[i].Value)
+		}
+		if pk.f == 0 {
+			pk.f = 0 // fold -0 into 0
+		}
+		id.WriteByte(pk.kind)
+		id.WriteString(strconv.Quote(pk.s))
+		id.WriteString(strconv.FormatFloat(pk.f, 'g', -1, 64))
+		terms = append(terms, KeyMatch{Key: k, Value: v})
+	}
+	return terms, id.String(), nil
+}
+
+func (c *KeyedArray) Test(rc *RuleContext, actual any) error {
+	if err := c.validate(); err != nil {
+		return ruleMismatch("$keyedBy", RuleFailed, c.items, actual, "%w", err)
+	}
+	act, ok := asArray(actual)
+	if !ok {
+		return ruleMismatch("$keyedBy", TypeMismatch, c.items, actual, "$keyedBy expects array/slice, got %T", actual)
+	}
+
+	var failed []*MismatchError
+	// fail records m, reporting whether the comparison should stop.
+	fail := func(ms ...*MismatchError) bool {
+		failed = append(failed, ms...)
+		return !rc.inner.collect
+	}
+	result := func() error {
+		switch {
+		case len(failed) == 0:
+			return nil
+		case rc.inner.collect:
+			return &MultiError{Mismatches: failed}
+		default:
+			return failed[0]
+		}
+	}
+
+	byID := make(map[string]int, len(act))
+	actTerms := make([][]KeyMatch, len(act))
+	for ai, a := range act {
+		doc, ok := asDocument(a)
+		if !ok {
+			if fail(ruleMismatch("$keyedBy", TypeMismatch, c.keys, a, "$keyedBy element %d must be an object, got %T", ai, a).at(Path{indexSeg(ai)})) {
+				return result()
+			}
+			continue
+		}
+		terms, id, err := c.identify(doc)
+		if err != nil {
+			if fail(ruleMismatch("$keyedBy", RuleFailed, c.keys, a, "$keyedBy element %d: %w", ai, err).at(Path{indexSeg(ai)})) {
+				return result()
+			}
+			continue
+		}
+		actTerms[ai] = terms
+		if prev, dup := byID[id]; dup {
+			if fail(ruleMismatch("$keyedBy", RuleFailed, c.keys, a, "$keyedBy duplicate key: elements %d and %d share the same key", prev, ai).at(Path{KeyedSegment(ai, terms...)})) {
+				return result()
+			}
+			continue
+		}
+		byID[id] = ai
+	}
+
+	used := make([]bool, len(act))
+	for _, item := range c.items {
+		terms, id, _ := c.identify(item.(jwalk.Document))
+		ai, found := byID[id]
+		if !found {
+			if rc.ignored(KeyedSegment(-1, terms...)) {
+				continue
+			}
+			if fail(ruleMismatch("$keyedBy", KeyNotFound, item, nil, "$keyedBy entity not found").at(Path{KeyedSegment(-1, terms...)})) {
+				return result()
+			}
+			continue
+		}
+		used[ai] = true
+		pop := rc.PushSegment(KeyedSegment(ai, terms...))
+		err := rc.Test(item, act[ai])
+		pop()
+		if err != nil && fail(mismatchesOf(err)...) {
+			return result()
+		}
+	}
+
+	if !c.allowExtra {
+		for ai, a := range act {
+			if used[ai] || actTerms[ai] == nil {
+				continue
+			}
+			seg := KeyedSegment(ai, actTerms[ai]...)
+			if rc.ignored(seg) {
+				continue
+			}
+			if fail(ruleMismatch("$keyedBy", UnexpectedKey, nil, a, "$keyedBy unexpected entity").at(Path{seg})) {
+				return result()
+			}
+		}
+	}
+	return result()
+}
+
+// EachElement applies one expectation to every element of the actual array,
+// reporting failures at [i]. An empty array passes.
+type EachElement struct{ expected any }
+
+func (c *EachElement) Test(rc *RuleContext, actual any) error {
+	act, ok := asArray(actual)
+	if !ok {
+		return ruleMismatch("$each", TypeMismatch, c.expected, actual, "$each expects array/slice, got %T", actual)
+	}
+	var failed []*MismatchError
+	for i, a := range act {
+		pop := rc.PushIndex(i)
+		err := rc.Test(c.expected, a)
+		pop()
+		if err == nil {
+			continue
+		}
+		if !rc.inner.collect {
+			return err
+		}
+		failed = append(failed, mismatchesOf(err)...)
+	}
+	if len(failed) > 0 {
+		return &MultiError{Mismatches: failed}
+	}
+	return nil
+}
+
+// SomeElement passes when at least one element of the actual array matches the
+// expectation. The captures of the first matching element are kept.
+type SomeElement struct{ expected any }
+
+func (c *SomeElement) Test(rc *RuleContext, actual any) error {
+	act, ok := asArray(actual)
+	if !ok {
+		return ruleMismatch("$some", TypeMismatch, c.expected, actual, "$some expects array/slice, got %T", actual)
+	}
+	for i, a := range act {
+		if ok, err := matchAt(rc, i, c.expected, a); ok || err != nil {
+			return err
+		}
+	}
+	return ruleMismatch("$some", RuleFailed, c.expected, actual, "$some failed: none of %d elements matched %v", len(act), c.expected)
+}
+
+// CountElements counts the elements of the actual array matching an
+// expectation and checks the count against integer bounds, like Length. The
+// captures of every matching element are kept, so an element whose captures
+// conflict with those of an earlier one does not match.
+type CountElements struct {
+	match any
+	eq    *int
+	lt    *int
+	lte   *int
+	gt    *int
+	gte   *int
+	// expected, when set, is compared against the count using Tester
+	// semantics (e.g. a number or a numeric comparator rule).
+	expected any
+}
+
+func (c *CountElements) Test(rc *RuleContext, actual any) error {
+	act, ok := asArray(actual)
+	if !ok {
+		return ruleMismatch("$count", TypeMismatch, c.match, actual, "$count expects array/slice, got %T", actual)
+	}
+	n := 0
+	for i, a := range act {
+		ok, err := matchAt(rc, i, c.match, a)
+		if err != nil {
+			return err
+		}
+		if ok {
+			n++
+		}
+	}
+	if c.expected != nil {
+		if err := rc.Test(c.expected, n); err != nil {
+			return ruleMismatch("$count", RuleFailed, c.expected, n, "$count failed: %d elements matched: %w", n, err)
+		}
+	}
+	if op, bound, failed := checkBounds(n, c.eq, c.lt, c.lte, c.gt, c.gte); failed {
+		return ruleMismatch("$count", RuleFailed, bound, n, "$count %s failed: %d elements matched, expected %s %d", op, n, boundSymbols[op], bound)
+	}
+	return nil
+}
+
+// HasType requires the actual value to be of one of the listed JSON types:
+// "string", "number", "integer", "boolean", "object", "array" or "null".
+// "number" also accepts integers, and "integer" accepts floats with an
+// integral value as decoded from JSON.
+type HasType struct{ types []string }
+
+func (c *HasType) Test(rc *RuleContext, actual any) error {
+	got := jsonTypeOf(actual)
+	for _, want := range c.types {
+		if want == got || (want == "number" && got == "integer") {
+			return nil
+		}
+	}
+	if len(c.types) == 1 {
+		return ruleMismatch("$type", TypeMismatch, c.types[0], actual, "expected type %s, got %s", c.types[0], got)
+	}
+	return ruleMismatch("$type", TypeMismatch, c.types, actual, "expected one of types %s, got %s", strings.Join(c.types, ", "), got)
+}
+
+func (c *HasType) validate() error {
+	if len(c.types) == 0 {
+		return errors.New("type directive requires at least one type")
+	}
+	for _, name := range c.types {
+		if !slices.Contains(jsonTypes, name) {
+			return fmt.Errorf("unknown type %q", name)
+		}
+	}
+	return nil
+}
+
+// MatchFormat requires the actual value to be a string in a named format, as
+// registered with RegisterFormat. Values implementing encoding.TextMarshaler
+// are checked in their text form.
+type MatchFormat struct{ name string }
+
+func (c *MatchFormat) Test(rc *RuleContext, actual any) error {
+	s, ok := asText(actual)
+	if !ok {
+		return ruleMismatch("$format", TypeMismatch, c.name, actual, "$format expects string, got %T", actual)
+	}
+	check, ok := lookupFormat(c.name)
+	if !ok {
+		return ruleMismatch("$format", RuleFailed, c.name, s, "%w %q", ErrUnknownFormat, c.name)
+	}
+	if err := check(s); err != nil {
+		return ruleMismatch("$format", RuleFailed, c.name, s, "string %q is not a valid %s: %w", s, c.name, err)
+	}
+	return nil
+}
+
+// timeRef is the reference instant of a time comparator: a fixed time or, when
+// now is set, the Tester's clock at comparison time.
+type timeRef struct {
+	t   time.Time
+	now bool
+}
+
+func (r timeRef) at(rc *RuleContext) time.Time {
+	if r.now {
+		return rc.Now()
+	}
+	return r.t
+}
+
+func (r timeRef) String() string {
+	if r.now {
+		return "now"
+	}
+	return r.t.Format(time.RFC3339Nano)
+}
+
+// parseTimeRef parses "now" or an RFC 3339 timestamp.
+func parseTimeRef(s string) (timeRef, error) {
+	if s == "now" {
+		return timeRef{now: true}, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return timeRef{}, err
+	}
+	return timeRef{t: t}, nil
+}
+
+// timeCompare compares an actual time, given as an RFC 3339 string or a
+// time.Time, with a reference instant. Times are compared as instants, so
+// offsets do not matter. op is "after", "before", "timeEq" or "within", the
+// latter allowing a difference of up to delta in either direction.
+type timeCompare struct {
+	op    string
+	ref   timeRef
+	delta time.Duration
+}
+
+func (c *timeCompare) Test(rc *RuleContext, actual any) error {
+	name := "$" + c.op
+	var got time.Time
+	switch v := plainValue(reflect.ValueOf(actual)).(type) {
+	case time.Time:
+		got = v
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return ruleMismatch(name, RuleFailed, c.ref.String(), actual, "%s expects an RFC 3339 time: %w", name, err)
+		}
+		got = t
+	default:
+		return ruleMismatch(name, TypeMismatch, c.ref.String(), actual, "%s expects RFC 3339 string or time.Time, got %T", name, actual)
+	}
+	ref := c.ref.at(rc)
+	show := got.Format(time.RFC3339Nano)
+	switch c.op {
+	case "after":
+		if !got.After(ref) {
+			return ruleMismatch(name, RuleFailed, c.ref.String(), actual, "$after failed: %s is not after %s", show, ref.Format(time.RFC3339Nano))
+		}
+	case "before":
+		if !got.Before(ref) {
+			return ruleMismatch(name, RuleFailed, c.ref.String(), actual, "$before failed: %s is not before %s", show, ref.Format(time.RFC3339Nano))
+		}
+	case "timeEq":
+		if !got.Equal(ref) {
+			return ruleMismatch(name, RuleFailed, c.ref.String(), actual, "$timeEq failed: %s is %s from %s", show, got.Sub(ref), ref.Format(time.RFC3339Nano))
+		}
+	case "within":
+		if d := got.Sub(ref); d > c.delta || d < -c.delta {
+			return ruleMismatch(name, RuleFailed, c.ref.String(), actual, "$within failed: %s is %s from %s, expected within %s", show, d, ref.Format(time.RFC3339Nano), c.delta)
+		}
+	default:
+		return ruleMismatch(name, RuleFailed, c.ref.String(), actual, "unknown time comparator")
+	}
+	return nil
+}
+
+// ApproxEqual requires the actual number to be within an absolute or relative
+// tolerance of the expected value; see WithFloatTolerance.
+type ApproxEqual struct {
+	value float64
+	tol   tolerance
+}
+
+func (c *ApproxEqual) Test(rc *RuleContext, actual any) error {
+	val, ok := toFloat64(plainValue(reflect.ValueOf(actual)))
+	if !ok {
+		return ruleMismatch("$approx", TypeMismatch, c.value, actual, "$approx expects numeric value, got %T", actual)
+	}
+	if !c.tol.close(c.value, val) {
+		return ruleMismatch("$approx", RuleFailed, c.value, actual, "$approx failed: got %v, expected %v (delta %.6g exceeds %s)", trimFloat(val), trimFloat(c.value), math.Abs(val-c.value), c.tol)
+	}
+	return nil
+}
+
+// stringNorm normalizes both sides of a string comparison. ignoreCase is not
+// applied to the strings; it makes the comparison fold case instead.
+type stringNorm struct {
+	ignoreCase         bool
+	trimSpace          bool
+	collapseWhitespace bool
+}
+
+// apply returns s with white space normalized, along with the character
+// offset in s of each character of the result and of its end.
+func (n stringNorm) apply(s string) (string, []int) {
+	runes := []rune(s)
+	lo, hi := 0, len(runes)
+	if n.trimSpace {
+		for lo < hi && unicode.IsSpace(runes[lo]) {
+			lo++
+		}
+		for hi > lo && unicode.IsSpace(runes[hi-1]) {
+			hi--
+		}
+	}
+	var b strings.Builder
+	at := make([]int, 0, hi-lo+1)
+	space := false
+	for i := lo; i < hi; i++ {
+		r := runes[i]
+		if n.collapseWhitespace && unicode.IsSpace(r) {
+			if space {
+				continue
+			}
+			space, r = true, ' '
+		} else {
+			space = false
+		}
+		b.WriteRune(r)
+		at = append(at, i)
+	}
+	return b.String(), append(at, hi)
+}
+
+// stringCompare checks the actual string against value after normalizing both.
+// op is "startsWith", "endsWith", "containsString" or "ieq" (equality, which
+// always ignores case). Case is compared with simple Unicode case folding, as
+// in strings.EqualFold. Failures quote the actual string as given and report
+// the character offset in it at which it diverges from value.
+type stringCompare struct {
+	op    string
+	value string
+	norm  stringNorm
+}
+
+func (c *stringCompare) Test(rc *RuleContext, actual any) error {
+	name := "$" + c.op
+	orig, ok := asText(actual)
+	if !ok {
+		return ruleMismatch(name, TypeMismatch, c.value, actual, "%s expects string, got %T", name, actual)
+	}
+	s, at := c.norm.apply(orig)
+	want, _ := c.norm.apply(c.value)
+	fold, n := c.norm.ignoreCase, utf8.RuneCountInString(want)
+	switch c.op {
+	case "startsWith":
+		if m := commonPrefix(s, want, fold); m < n {
+			return ruleMismatch(name, RuleFailed, c.value, actual, "$startsWith failed: %q does not start with %q (diverges at offset %d)", orig, c.value, at[m])
+		}
+	case "endsWith":
+		if m := commonSuffix(s, want, fold); m < n {
+			return ruleMismatch(name, RuleFailed, c.value, actual, "$endsWith failed: %q does not end with %q (diverges at offset %d)", orig, c.value, at[max(len(at)-m-2, 0)])
+		}
+	case "containsString":
+		if off, m := longestPartialMatch(s, want, fold); m < n {
+			if m == 0 {
+				return ruleMismatch(name, RuleFailed, c.value, actual, "$containsString failed: %q does not contain %q", orig, c.value)
+			}
+			return ruleMismatch(name, RuleFailed, c.value, actual, "$containsString failed: %q does not contain %q (closest match at offset %d diverges after %d characters)", orig, c.value, at[off], m)
+		}
+	case "ieq":
+		if !strings.EqualFold(s, want) {
+			return ruleMismatch(name, RuleFailed, c.value, actual, "$ieq failed: %q differs from %q at offset %d", orig, c.value, at[commonPrefix(s, want, true)])
+		}
+	default:
+		return ruleMismatch(name, RuleFailed, c.value, actual, "unknown string comparator")
+	}
+	return nil
+}
+
+// sameRune reports whether a and b are equal or, when fold is set, equal under
+// simple Unicode case folding.
+func sameRune(a, b rune, fold bool) bool {
+	if a == b {
+		return true
+	}
+	if !fold {
+		return false
+	}
+	for r := unicode.SimpleFold(a); r != a; r = unicode.SimpleFold(r) {
+		if r == b {
+			return true
+		}
+	}
+	return false
+}
+
+// commonPrefix returns the number of leading characters a and b share.
+func commonPrefix(a, b string, fold bool) int {
+	n := 0
+	for a != "" && b != "" {
+		ra, sa := utf8.DecodeRuneInString(a)
+		rb, sb := utf8.DecodeRuneInString(b)
+		if !sameRune(ra, rb, fold) {
+			break
+		}
+		a, b = a[sa:], b[sb:]
+		n++
+	}
+	return n
+}
+
+// commonSuffix returns the number of trailing characters a and b share.
+func commonSuffix(a, b string, fold bool) int {
+	n := 0
+	for a != "" && b != "" {
+		ra, sa := utf8.DecodeLastRuneInString(a)
+		rb, sb := utf8.DecodeLastRuneInString(b)
+		if !sameRune(ra, rb, fold) {
+			break
+		}
+		a, b = a[:len(a)-sa], b[:len(b)-sb]
+		n++
+	}
+	return n
+}
+
+// longestPartialMatch returns the character offset in s at which the longest
+// prefix of sub starts, along with that prefix's length in characters.
+func longestPartialMatch(s, sub string, fold bool) (at, n int) {
+	for i, off := 0, 0; off < len(s); i++ {
+		if m := commonPrefix(s[off:], sub, fold); m > n {
+			at, n = i, m
+		}
+		_, size := utf8.DecodeRuneInString(s[off:])
+		off += size
+	}
+	return at, n
+}
+
+// ParseJSON decodes an actual string (or []byte) holding embedded JSON and
+// compares the decoded value with expected under Tester semantics, so paths
+// continue inside it. The JSON is decoded with the unmarshalers the directive
+// itself was decoded with, i.e. the same jwalk registry; rules built in Go,
+// whose expectation was not decoded with a registry, decode it as plain JSON.
+type ParseJSON struct {
+	expected     any
+	unmarshalers *json.Unmarshalers
+}
+
+func (c *ParseJSON) Test(rc *RuleContext, actual any) error {
+	var data []byte
+	if b, ok := actual.([]byte); ok {
+		data = b
+	} else if s, ok := asText(actual); ok {
+		data = []byte(s)
+	} else {
+		return ruleMismatch("$parseJSON", TypeMismatch, c.expected, actual, "$parseJSON expects string, got %T", actual)
+	}
+	decoded, err := decodeEmbeddedJSON(data, c.unmarshalers)
+	if err != nil {
+		return ruleMismatch("$parseJSON", RuleFailed, c.expected, actual, "$parseJSON failed: invalid JSON: %w", err)
+	}
+	return rc.Test(c.expected, decoded)
+}
+
+// decodeEmbeddedJSON decodes actual data holding JSON with unmarshalers, which
+// carry the jwalk registry the expectation was decoded with, so objects and
+// arrays become jwalk values as in a top-level actual decoded with it. Without
+// unmarshalers the data is decoded as plain JSON.
+func decodeEmbeddedJSON(data []byte, unmarshalers *json.Unmarshalers) (any, error) {
+	var opts []json.Options
+	if unmarshalers != nil {
+		opts = append(opts, json.WithUnmarshalers(unmarshalers))
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded, opts...); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// DecodeBase64 decodes an actual base64 string and compares the result with
+// expected: as a string (the default), as []byte or, after decoding it as JSON
+// like ParseJSON, as a JSON value. Both the standard and the URL-safe alphabet
+// are supported, with or without padding.
+type DecodeBase64 struct {
+	expected     any
+	as           string // "string", "bytes" or "json"
+	url          bool
+	unmarshalers *json.Unmarshalers
+}
+
+// AsBytes compares the decoded bytes as []byte, like "as": "bytes".
+func (c *DecodeBase64) AsBytes() *DecodeBase64 {
+	c.as = "bytes"
+	return c
+}
+
+// AsJSON decodes the decoded bytes as JSON, like "as": "json".
+func (c *DecodeBase64) AsJSON() *DecodeBase64 {
+	c.as = "json"
+	return c
+}
+
+// URLEncoding selects the URL-safe alphabet, like "encoding": "url".
+func (c *DecodeBase64) URLEncoding() *DecodeBase64 {
+	c.url = true
+	return c
+}
+
+func (c *DecodeBase64) Test(rc *RuleContext, actual any) error {
+	s, ok := asText(actual)
+	if !ok {
+		return ruleMismatch("$base64", TypeMismatch, c.expected, actual, "$base64 expects string, got %T", actual)
+	}
+	enc := base64.RawStdEncoding
+	if c.url {
+		enc = base64.RawURLEncoding
+	}
+	data, err := decodeBase64(enc, s)
+	if err != nil {
+		return ruleMismatch("$base64", RuleFailed, c.expected, actual, "$base64 failed: invalid base64: %w", err)
+	}
+	switch c.as {
+	case "bytes":
+		return rc.Test(c.expected, data)
+	case "json":
+		decoded, err := decodeEmbeddedJSON(data, c.unmarshalers)
+		if err != nil {
+			return ruleMismatch("$base64", RuleFailed, c.expected, actual, "$base64 failed: invalid JSON: %w", err)
+		}
+		return rc.Test(c.expected, decoded)
+	default:
+		return rc.Test(c.expected, string(data))
+	}
+}
+
+// DecodeJWT decodes an actual JSON Web Token in compact form, without
+// verifying its signature, into a document holding the decoded "header" and
+// "claims" and the base64url "signature", which is compared with expected.
+// The header and claims are decoded like ParseJSON.
+type DecodeJWT struct {
+	expected     any
+	unmarshalers *json.Unmarshalers
+}
+
+func (c *DecodeJWT) Test(rc *RuleContext, actual any) error {
+	s, ok := asText(actual)
+	if !ok {
+		return ruleMismatch("$jwt", TypeMismatch, c.expected, actual, "$jwt expects string, got %T", actual)
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) != 3 {
+		return ruleMismatch("$jwt", RuleFailed, c.expected, actual, "$jwt failed: expected 3 dot-separated segments, got %d", len(parts))
+	}
+	token := make(jwalk.Document, 0, 3)
+	for i, name := range []string{"header", "claims"} {
+		data, err := decodeBase64(base64.RawURLEncoding, parts[i])
+		if err != nil {
+			return ruleMismatch("$jwt", RuleFailed, c.expected, actual, "$jwt failed: invalid %s encoding: %w", name, err)
+		}
+		decoded, err := decodeEmbeddedJSON(data, c.unmarshalers)
+		if err != nil {
+			return ruleMismatch("$jwt", RuleFailed, c.expected, actual, "$jwt failed: invalid %s JSON: %w", name, err)
+		}
+		token = append(token, jwalk.Entry{Key: name, Value: decoded})
+	}
+	token = append(token, jwalk.Entry{Key: "signature", Value: parts[2]})
+	return rc.Test(c.expected, token)
+}
+
+// ParseURL parses an actual URL string into a document with "scheme", "host"
+// (including any port), "path", "query" and "fragment", which is compared with
+// expected so that subset semantics apply to its parts. "query" is a document
+// keyed by parameter name, in sorted order; a parameter given once maps to its
+// value and one given several times to an array of values.
+type ParseURL struct{ expected any }
+
+func (c *ParseURL) Test(rc *RuleContext, actual any) error {
+	s, ok := asText(actual)
+	if !ok {
+		return ruleMismatch("$url", TypeMismatch, c.expected, actual, "$url expects string, got %T", actual)
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ruleMismatch("$url", RuleFailed, c.expected, actual, "$url failed: %w", err)
+	}
+	values := u.Query()
+	query := make(jwalk.Document, 0, len(values))
+	for _, k := range slices.Sorted(maps.Keys(values)) {
+		var v any = values[k][0]
+		if len(values[k]) > 1 {
+			arr := make(jwalk.Array, len(values[k]))
+			for i, s := range values[k] {
+				arr[i] = s
+			}
+			v = arr
+		}
+		query = append(query, jwalk.Entry{Key: k, Value: v})
+	}
+	return rc.Test(c.expected, jwalk.Document{
+		{Key: "scheme", Value: u.Scheme},
+		{Key: "host", Value: u.Host},
+		{Key: "path", Value: u.Path},
+		{Key: "query", Value: query},
+		{Key: "fragment", Value: u.Fragment},
+	})
+}
+
+// ErrUnboundRef is wrapped by "$ref" mismatches naming a value that was never
+// captured.
+var ErrUnboundRef = errors.New("reference not bound")
+
+// captureSet holds the "$capture" bindings made during one Tester.Test call,
+// on top of those a Tester with persistent captures kept from earlier calls
+// (prior). Rules that try alternatives or candidates run them on a child set,
+// whose bindings reach its parent only through commitCaptures, so a branch
+// that fails, or is not chosen, leaves no binding behind.
+type captureSet struct {
+	parent *captureSet
+	prior  map[string]any
+	bound  map[string]any
+}
+
+// lookup returns the value bound to name in the current comparison or, if
+// prior is set, kept from earlier calls.
+func (s *captureSet) lookup(name string, prior bool) (any, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.bound[name]; ok {
+			return v, true
+		}
+		if v, ok := s.prior[name]; ok && prior {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (s *captureSet) bind(name string, v any) {
+	if s.bound == nil {
+		s.bound = make(map[string]any)
+	}
+	s.bound[name] = v
+}
+
+// child returns an empty set staging bindings on top of s, which may be nil.
+func (s *captureSet) child() *captureSet {
+	return &captureSet{parent: s}
+}
+
+// captures returns the bindings of the current comparison, starting an empty
+// set for contexts created outside Tester.Test.
+func (rc *RuleContext) captures() *captureSet {
+	if rc.inner.captures == nil {
+		rc.inner.captures = &captureSet{}
+	}
+	return rc.inner.captures
+}
+
+// capture binds v under name, failing when name is already bound to a
+// different value in the current comparison.
+func (rc *RuleContext) capture(name string, v any) error {
+	caps := rc.captures()
+	if prev, ok := caps.lookup(name, false); ok {
+		if err := rc.testSame(prev, v, true); err != nil {
+			return ruleMismatch("$capture", RuleFailed, prev, v, "$capture %q conflicts with the value captured earlier: %w", name, err)
+		}
+		return nil
+	}
+	caps.bind(name, v)
+	return nil
+}
+
+// staged runs fn, typically a call to Test for one alternative of a rule, with
+// "$capture" binding into a new child set, which it returns for the rule to
+// commit with commitCaptures if it keeps the alternative.
+func (rc *RuleContext) staged(fn func() error) (*captureSet, error) {
+	prev := rc.inner.captures
+	s := prev.child()
+	rc.inner.captures = s
+	defer func() { rc.inner.captures = prev }()
+	return s, fn()
+}
+
+// commitCaptures adds the bindings staged in s to the current ones, in name
+// order, failing like "$capture" on a conflicting value. The mismatch path is
+// relative to the rule's value, including segments pushed with PushIndex.
+func (rc *RuleContext) commitCaptures(s *captureSet) error {
+	for _, name := range slices.Sorted(maps.Keys(s.bound)) {
+		if err := rc.capture(name, s.bound[name]); err != nil {
+			return mismatchesOf(err)[0].at(rc.inner.path[rc.depth:])
+		}
+	}
+	return nil
+}
+
+// testSame compares actual with a previously seen actual value v for equality:
+// v is turned into an expectation and compared strictly.
+func (rc *RuleContext) testSame(v, actual any, speculative bool) error {
+	defer rc.withModes(ObjectStrict, ArrayStrict)()
+	if speculative {
+		return rc.probe(asExpected(v), actual)
+	}
+	return rc.Test(asExpected(v), actual)
+}
+
+// asExpected converts an actual value into an expectation matching it, with
+// objects and arrays as jwalk values.
+func asExpected(v any) any {
+	if doc, ok := asDocument(v); ok {
+		exp := make(jwalk.Document, len(doc))
+		for i, e := range doc {
+			exp[i] = jwalk.Entry{Key: e.Key, Value: asExpected(e.Value)}
+		}
+		return exp
+	}
+	if arr, ok := asArray(v); ok {
+		exp := make(jwalk.Array, len(arr))
+		for i, e := range arr {
+			exp[i] = asExpected(e)
+		}
+		return exp
+	}
+	return v
+}
+
+// CaptureValue binds the actual value under a name for "$ref" to compare
+// against later in the same comparison, or in later calls to Test on a Tester
+// built with WithPersistentCaptures (see Tester.Captures). It always matches,
+// except that capturing a different value under the same name twice in one
+// comparison fails. Only bindings made on the path that decides the outcome
+// are kept: those of the $or alternative that matched and of the elements
+// paired by unordered array matching, $some and $count, but none made under
+// $not or $nor.
+type CaptureValue struct{ name string }
+
+func (c *CaptureValue) Test(rc *RuleContext, actual any) error {
+	return rc.capture(c.name, actual)
+}
+
+// RefValue requires the actual value to equal the one captured under a name
+// by "$capture", earlier in document order or by a previous call to Test on a
+// Tester with persistent captures. Objects and arrays must match exactly.
+type RefValue struct{ name string }
+
+func (c *RefValue) Test(rc *RuleContext, actual any) error {
+	v, ok := rc.captures().lookup(c.name, true)
+	if !ok {
+		return ruleMismatch("$ref", RuleFailed, c.name, actual, "$ref %q: %w", c.name, ErrUnboundRef)
+	}
+	return rc.testSame(v, actual, false)
+}
+
+// ErrUnknownDefinition is wrapped by "$use" mismatches naming a definition
+// that is neither in an enclosing "$defs" section nor registered with
+// WithDefinition.
+var ErrUnknownDefinition = errors.New("unknown definition")
+
+// defScope is a set of definitions visible to "$use", chained to those of the
+// enclosing scope.
+type defScope struct {
+	defs   map[string]any
+	parent *defScope
+}
+
+func (s *defScope) lookup(name string) (any, bool) {
+	for ; s != nil; s = s.parent {
+		if exp, ok := s.defs[name]; ok {
+			return exp, true
+		}
+	}
+	return nil, false
+}
+
+// Definitions makes named expectations available to "$use" within expected.
+// In JSON, "$defs" is the first member of a document and the document's other
+// members form expected.
+type Definitions struct {
+	defs     map[string]any
+	expected any
+}
+
+func (c *Definitions) Test(rc *RuleContext, actual any) error {
+	defer rc.withDefs(c.defs)()
+	return rc.Test(c.expected, actual)
+}
+
+func (c *Definitions) TestAbsent(rc *RuleContext) error {
+	defer rc.withDefs(c.defs)()
+	return rc.TestAbsent(c.expected)
+}
+
+// UseDefinition compares the actual value with the named definition. A
+// definition may use itself below the value it describes, e.g. for the
+// children of a tree node.
+type UseDefinition struct{ name string }
+
+func (c *UseDefinition) Test(rc *RuleContext, actual any) error {
+	exp, restore, err := rc.use(c.name)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	return rc.Test(exp, actual)
+}
+
+func (c *UseDefinition) TestAbsent(rc *RuleContext) error {
+	exp, restore, err := rc.use(c.name)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	return rc.TestAbsent(exp)
+}
+
+// withDefs adds a scope of definitions for the nested comparisons run by
+// Test; the returned function removes it.
+func (rc *RuleContext) withDefs(defs map[string]any) (restore func()) {
+	prev := rc.inner.defs
+	rc.inner.defs = &defScope{defs: defs, parent: prev}
+	return func() { rc.inner.defs = prev }
+}
+
+// use resolves a definition for "$use" and marks it as being expanded at the
+// current depth until restore is called, failing if it already is.
+func (rc *RuleContext) use(name string) (expected any, restore func(), err error) {
+	exp, ok := rc.inner.defs.lookup(name)
+	if !ok {
+		return nil, nil, ruleMismatch("$use", RuleFailed, name, nil, "$use %q: %w", name, ErrUnknownDefinition)
+	}
+	depth := len(rc.inner.base) + len(rc.inner.path)
+	using := rc.inner.using
+	if rc.inner.useDepth != depth {
+		using = nil
+	}
+	if slices.Contains(using, name) {
+		return nil, nil, ruleMismatch("$use", RuleFailed, name, nil, "$use %q: definition refers to itself without descending into the value", name)
+	}
+	prevUsing, prevDepth := rc.inner.using, rc.inner.useDepth
+	rc.inner.using, rc.inner.useDepth = append(slices.Clip(using), name), depth
+	return exp, func() { rc.inner.using, rc.inner.useDepth = prevUsing, prevDepth }, nil
+}
@@ -0,0 +1,383 @@
+package testequals
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/calumari/jwalk"
+)
+
+// The constructors below build the builtin rules directly in Go so that
+// expectations can be written inline in tests without going through the JSON
+// directives. They mirror the directive payloads one to one, e.g.
+//
+//	testequals.Obj(
+//		"user", testequals.Obj(
+//			"name", testequals.Regex("^Al"),
+//			"tags", testequals.Len(testequals.Gte(1)),
+//		),
+//	)
+
+// Obj builds an expected jwalk.Document from alternating keys and values. It
+// panics if given an odd number of arguments or a non-string key.
+func Obj(kv ...any) jwalk.Document {
+	if len(kv)%2 != 0 {
+		panic(fmt.Sprintf("testequals.Obj: odd number of arguments (%d)", len(kv)))
+	}
+	doc := make(jwalk.Document, 0, len(kv)/2)
+	for i := 0; i < len(kv); i += 2 {
+		k, ok := kv[i].(string)
+		if !ok {
+			panic(fmt.Sprintf("testequals.Obj: key at index %d is %T, not string", i, kv[i]))
+		}
+		doc = append(doc, jwalk.Entry{Key: k, Value: kv[i+1]})
+	}
+	return doc
+}
+
+// Arr builds an expected jwalk.Array from the given elements.
+func Arr(elems ...any) jwalk.Array {
+	return jwalk.Array(elems)
+}
+
+// Eq is the Go equivalent of "$eq": strict deep equality for the subtree.
+func Eq(expected any) *Equal {
+	return &Equal{expected: expected}
+}
+
+// Ne is the Go equivalent of "$ne".
+func Ne(expected any) *NotEqual {
+	return &NotEqual{expected: expected}
+}
+
+// IsNil is the Go equivalent of {"$nil": true}.
+func IsNil() *Nil {
+	return &Nil{expected: true, wanted: true}
+}
+
+// NotNil is the Go equivalent of {"$nil": false}.
+func NotNil() *Nil {
+	return &Nil{expected: true, wanted: false}
+}
+
+// IsRequired is the Go equivalent of {"$required": true}.
+func IsRequired() *Required {
+	return &Required{want: true}
+}
+
+// Anything is the Go equivalent of "$any".
+func Anything() *Any {
+	return &Any{}
+}
+
+// Regex is the Go equivalent of "$regex". It panics if expr does not compile.
+func Regex(expr string) *MatchString {
+	return &MatchString{re: regexp.MustCompile(expr)}
+}
+
+// Unordered is the Go equivalent of "$elementsMatch".
+func Unordered(elems ...any) *ElementsMatch {
+	return &ElementsMatch{expected: elems}
+}
+
+// Len is the Go equivalent of "$length". expected is compared against the
+// length of the actual array, so it may be a number or any rule over numbers
+// (e.g. Len(Gte(1)) or Len(AllOf(Gt(0), Lt(10)))).
+func Len(expected any) *Length {
+	return &Length{expected: expected}
+}
+
+// IsEmpty is the Go equivalent of {"$empty": true}.
+func IsEmpty() *Empty {
+	return &Empty{want: true}
+}
+
+// NotEmpty is the Go equivalent of {"$empty": false}.
+func NotEmpty() *Empty {
+	return &Empty{want: false}
+}
+
+// Lt is the Go equivalent of "$lt".
+func Lt(n float64) Rule {
+	return &numericCompare{op: "lt", ref: n}
+}
+
+// Lte is the Go equivalent of "$lte".
+func Lte(n float64) Rule {
+	return &numericCompare{op: "lt", ref: n, incl: true}
+}
+
+// Gt is the Go equivalent of "$gt".
+func Gt(n float64) Rule {
+	return &numericCompare{op: "gt", ref: n}
+}
+
+// Gte is the Go equivalent of "$gte".
+func Gte(n float64) Rule {
+	return &numericCompare{op: "gt", ref: n, incl: true}
+}
+
+// In is the Go equivalent of "$in".
+func In(elems ...any) *InSet {
+	return &InSet{elems: elems}
+}
+
+// AllOf is the Go equivalent of "$and".
+func AllOf(rules ...any) *And {
+	return &And{rules: rules}
+}
+
+// AnyOf is the Go equivalent of "$or".
+func AnyOf(rules ...any) *Or {
+	return &Or{rules: rules}
+}
+
+// NoneOf is the Go equivalent of "$nor".
+func NoneOf(rules ...any) *Nor {
+	return &Nor{rules: rules}
+}
+
+// Negate is the Go equivalent of "$not".
+func Negate(rule any) *Not {
+	return &Not{rule: rule}
+}
+
+// Present is the Go equivalent of {"$exists": true}.
+func Present() *Exists {
+	return &Exists{want: true}
+}
+
+// Absent is the Go equivalent of {"$exists": false}.
+func Absent() *Exists {
+	return &Exists{want: false}
+}
+
+// IfPresent is the Go equivalent of "$optional".
+func IfPresent(expected any) *Optional {
+	return &Optional{expected: expected}
+}
+
+// Contains is the Go equivalent of "$contains".
+func Contains(elems ...any) *ArrayContains {
+	return &ArrayContains{expected: elems}
+}
+
+// ContainsInOrder is the Go equivalent of "$containsInOrder".
+func ContainsInOrder(elems ...any) *ArrayContainsInOrder {
+	return &ArrayContainsInOrder{expected: elems}
+}
+
+// KeyedBy is the Go equivalent of "$keyedBy" with a single key field. Call
+// AllowExtra on the result to permit extra actual elements.
+func KeyedBy(key string, items ...any) *KeyedArray {
+	return &KeyedArray{keys: []string{key}, items: items}
+}
+
+// KeyedByFields is the Go equivalent of "$keyedBy" with a composite key.
+func KeyedByFields(keys []string, items ...any) *KeyedArray {
+	return &KeyedArray{keys: keys, items: items}
+}
+
+// Each is the Go equivalent of "$each".
+func Each(expected any) *EachElement {
+	return &EachElement{expected: expected}
+}
+
+// Some is the Go equivalent of "$some".
+func Some(expected any) *SomeElement {
+	return &SomeElement{expected: expected}
+}
+
+// Count is the Go equivalent of "$count": the number of elements matching
+// match is compared against expected, e.g. Count(Obj("active", true), Gte(2)).
+// It panics if expected is nil, as the directive requires a comparator.
+func Count(match, expected any) *CountElements {
+	if expected == nil {
+		panic("testequals: no count comparator provided")
+	}
+	return &CountElements{match: match, expected: expected}
+}
+
+// OfType is the Go equivalent of "$type". It panics if a type name is
+// unknown.
+func OfType(types ...string) *HasType {
+	c := &HasType{types: types}
+	if err := c.validate(); err != nil {
+		panic("testequals: " + err.Error())
+	}
+	return c
+}
+
+// Format is the Go equivalent of "$format".
+func Format(name string) *MatchFormat {
+	return &MatchFormat{name: name}
+}
+
+// After is the Go equivalent of "$after" with a fixed instant.
+func After(t time.Time) Rule {
+	return &timeCompare{op: "after", ref: timeRef{t: t}}
+}
+
+// AfterNow is the Go equivalent of {"$after": "now"}.
+func AfterNow() Rule {
+	return &timeCompare{op: "after", ref: timeRef{now: true}}
+}
+
+// Before is the Go equivalent of "$before" with a fixed instant.
+func Before(t time.Time) Rule {
+	return &timeCompare{op: "before", ref: timeRef{t: t}}
+}
+
+// BeforeNow is the Go equivalent of {"$before": "now"}.
+func BeforeNow() Rule {
+	return &timeCompare{op: "before", ref: timeRef{now: true}}
+}
+
+// TimeEq is the Go equivalent of "$timeEq": the actual time must be the same
+// instant as t, in any offset.
+func TimeEq(t time.Time) Rule {
+	return &timeCompare{op: "timeEq", ref: timeRef{t: t}}
+}
+
+// Within is the Go equivalent of "$within" with a fixed instant. It panics if
+// delta is negative.
+func Within(of time.Time, delta time.Duration) Rule {
+	return within(timeRef{t: of}, delta)
+}
+
+// WithinNow is the Go equivalent of {"$within": {"of": "now", "delta": ...}}.
+// It panics if delta is negative.
+func WithinNow(delta time.Duration) Rule {
+	return within(timeRef{now: true}, delta)
+}
+
+func within(ref timeRef, delta time.Duration) Rule {
+	if delta < 0 {
+		panic("testequals: within delta must be non-negative")
+	}
+	return &timeCompare{op: "within", ref: ref, delta: delta}
+}
+
+// Approx is the Go equivalent of "$approx": the actual number must be within
+// abs, or rel times the larger magnitude, of value. It panics if a tolerance
+// is negative.
+func Approx(value, abs, rel float64) *ApproxEqual {
+	if abs < 0 || rel < 0 {
+		panic("testequals: approx tolerances must be non-negative")
+	}
+	return &ApproxEqual{value: value, tol: tolerance{abs: abs, rel: rel}}
+}
+
+// StringOption sets a normalization applied to both sides of the string
+// rules StartsWith, EndsWith, ContainsString and EqualFold.
+type StringOption func(*stringNorm)
+
+// IgnoreCase compares strings with Unicode case folding ("ignoreCase").
+func IgnoreCase() StringOption {
+	return func(n *stringNorm) { n.ignoreCase = true }
+}
+
+// TrimSpace strips leading and trailing white space ("trimSpace").
+func TrimSpace() StringOption {
+	return func(n *stringNorm) { n.trimSpace = true }
+}
+
+// CollapseWhitespace replaces every run of white space with a single space
+// ("collapseWhitespace").
+func CollapseWhitespace() StringOption {
+	return func(n *stringNorm) { n.collapseWhitespace = true }
+}
+
+func newStringCompare(op, value string, opts []StringOption) *stringCompare {
+	c := &stringCompare{op: op, value: value}
+	for _, opt := range opts {
+		opt(&c.norm)
+	}
+	return c
+}
+
+// StartsWith is the Go equivalent of "$startsWith".
+func StartsWith(prefix string, opts ...StringOption) Rule {
+	return newStringCompare("startsWith", prefix, opts)
+}
+
+// EndsWith is the Go equivalent of "$endsWith".
+func EndsWith(suffix string, opts ...StringOption) Rule {
+	return newStringCompare("endsWith", suffix, opts)
+}
+
+// ContainsString is the Go equivalent of "$containsString".
+func ContainsString(sub string, opts ...StringOption) Rule {
+	return newStringCompare("containsString", sub, opts)
+}
+
+// EqualFold is the Go equivalent of "$ieq": case-insensitive string equality.
+func EqualFold(s string, opts ...StringOption) Rule {
+	c := newStringCompare("ieq", s, opts)
+	c.norm.ignoreCase = true
+	return c
+}
+
+// ParseJSONOf is the Go equivalent of "$parseJSON". Without a registry, actual
+// strings are decoded as plain JSON.
+func ParseJSONOf(expected any) *ParseJSON {
+	return &ParseJSON{expected: expected}
+}
+
+// Base64Of is the Go equivalent of "$base64": the decoded string is compared
+// with expected. Use AsBytes, AsJSON and URLEncoding to configure it, e.g.
+// Base64Of(Obj("id", 1)).AsJSON().
+func Base64Of(expected any) *DecodeBase64 {
+	return &DecodeBase64{expected: expected, as: "string"}
+}
+
+// JWTOf is the Go equivalent of "$jwt", e.g.
+// JWTOf(Obj("claims", Obj("sub", "42"))).
+func JWTOf(expected any) *DecodeJWT {
+	return &DecodeJWT{expected: expected}
+}
+
+// URLOf is the Go equivalent of "$url", e.g.
+// URLOf(Obj("host", "example.com", "query", Obj("state", "abc"))).
+func URLOf(expected any) *ParseURL {
+	return &ParseURL{expected: expected}
+}
+
+// Capture is the Go equivalent of "$capture".
+func Capture(name string) *CaptureValue {
+	return &CaptureValue{name: name}
+}
+
+// Ref is the Go equivalent of "$ref".
+func Ref(name string) *RefValue {
+	return &RefValue{name: name}
+}
+
+// Defs is the Go equivalent of a "$defs" section: defs are available to Use
+// within expected.
+func Defs(defs map[string]any, expected any) *Definitions {
+	return &Definitions{defs: defs, expected: expected}
+}
+
+// Use is the Go equivalent of "$use".
+func Use(name string) *UseDefinition {
+	return &UseDefinition{name: name}
+}
+
+// Subset is the Go equivalent of "$subset": documents within expected are
+// compared with subset semantics.
+func Subset(expected any) *Mode {
+	return &Mode{objects: ObjectSubset, expected: expected}
+}
+
+// Strict is the Go equivalent of "$strict": documents and arrays within
+// expected are compared strictly.
+func Strict(expected any) *Mode {
+	return &Mode{objects: ObjectStrict, arrays: ArrayStrict, expected: expected}
+}
+
+// InMode is the Go equivalent of "$mode"; a zero mode keeps the inherited one.
+func InMode(objects ObjectMode, arrays ArrayMode, expected any) *Mode {
+	return &Mode{objects: objects, arrays: arrays, expected: expected}
+}
@@ -0,0 +1,204 @@
+package testequals
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/calumari/jwalk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObj(t *testing.T) {
+	t.Run("pairs build document succeeds", func(t *testing.T) {
+		got := Obj("a", 1, "b", "x")
+		assert.Equal(t, jwalk.Document{{Key: "a", Value: 1}, {Key: "b", Value: "x"}}, got)
+	})
+
+	t.Run("odd arguments panics", func(t *testing.T) {
+		assert.Panics(t, func() { Obj("a") })
+	})
+
+	t.Run("non-string key panics", func(t *testing.T) {
+		assert.Panics(t, func() { Obj(1, 2) })
+	})
+}
+
+func TestArr(t *testing.T) {
+	t.Run("elements build array succeeds", func(t *testing.T) {
+		assert.Equal(t, jwalk.Array{1, "a"}, Arr(1, "a"))
+	})
+}
+
+func TestBuilderConstructors(t *testing.T) {
+	reg, err := NewRegistry()
+	require.NoError(t, err)
+	ts := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	tester := New(WithClock(func() time.Time { return ts }))
+	token := "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJzdWIiOiI0MiIsImFkbWluIjp0cnVlfQ.c2ln"
+
+	// Each constructor is compared with its directive under the key "v" of a
+	// document, against a missing key and every actual value. Messages may
+	// differ where Go operands keep their type, so failures are compared by
+	// rule, kind and path.
+	outcome := func(err error) []string {
+		var out []string
+		for _, m := range mismatchesOf(err) {
+			out = append(out, fmt.Sprintf("%s %s %s", m.Rule, m.Kind, m.Path))
+		}
+		return out
+	}
+	tests := []struct {
+		name   string
+		rule   any
+		json   string
+		actual []any
+	}{
+		{"Eq", Eq(Obj("a", 1)), `{"$eq": {"a": 1}}`, []any{jwalk.Document{{Key: "a", Value: 1}}, jwalk.Document{{Key: "a", Value: 1}, {Key: "b", Value: 2}}, jwalk.Document{{Key: "a", Value: 2}}}},
+		{"Ne", Ne(1), `{"$ne": 1}`, []any{1, 2, "1"}},
+		{"IsNil", IsNil(), `{"$nil": true}`, []any{nil, 1}},
+		{"NotNil", NotNil(), `{"$nil": false}`, []any{nil, 1}},
+		{"IsRequired", IsRequired(), `{"$required": true}`, []any{"", "x", nil}},
+		{"Anything", Anything(), `{"$any": true}`, []any{nil, 1}},
+		{"Regex", Regex("^Al"), `{"$regex": "^Al"}`, []any{"Alice", "Bob", 1}},
+		{"Unordered", Unordered(1, 2), `{"$elementsMatch": [1, 2]}`, []any{jwalk.Array{2, 1}, jwalk.Array{1, 1}, jwalk.Array{1}}},
+		{"Len", Len(2), `{"$length": 2}`, []any{jwalk.Array{1, 2}, jwalk.Array{1}, "ab"}},
+		{"Len bounds", Len(Gte(1)), `{"$length": {"gte": 1}}`, []any{jwalk.Array{1}, jwalk.Array{}}},
+		{"IsEmpty", IsEmpty(), `{"$empty": true}`, []any{jwalk.Array{}, "", jwalk.Array{1}, "x"}},
+		{"NotEmpty", NotEmpty(), `{"$empty": false}`, []any{jwalk.Array{}, "", jwalk.Array{1}, "x"}},
+		{"Lt", Lt(1), `{"$lt": 1}`, []any{0, 1, 2, "0"}},
+		{"Lte", Lte(1), `{"$lte": 1}`, []any{0, 1, 2}},
+		{"Gt", Gt(1), `{"$gt": 1}`, []any{0, 1, 2}},
+		{"Gte", Gte(1), `{"$gte": 1}`, []any{0, 1, 2}},
+		{"In", In(1, "a"), `{"$in": [1, "a"]}`, []any{1, "a", 2}},
+		{"AllOf", AllOf(Gt(0), Lt(2)), `{"$and": [{"$gt": 0}, {"$lt": 2}]}`, []any{1, 2}},
+		{"AnyOf", AnyOf(1, Absent()), `{"$or": [1, {"$exists": false}]}`, []any{1, 2}},
+		{"NoneOf", NoneOf(1, 2), `{"$nor": [1, 2]}`, []any{1, 3}},
+		{"Negate", Negate(Present()), `{"$not": {"$exists": true}}`, []any{1}},
+		{"Present", Present(), `{"$exists": true}`, []any{nil, 1}},
+		{"Absent", Absent(), `{"$exists": false}`, []any{nil}},
+		{"IfPresent", IfPresent(1), `{"$optional": 1}`, []any{1, 2}},
+		{"Contains", Contains(1), `{"$contains": [1]}`, []any{[]any{2, 1}, []any{2}}},
+		{"ContainsInOrder", ContainsInOrder(1, 2), `{"$containsInOrder": [1, 2]}`, []any{[]any{1, 3, 2}, []any{2, 1}}},
+		{"KeyedBy", KeyedBy("id", Obj("id", 1, "n", "a")), `{"$keyedBy": {"key": "id", "items": [{"id": 1, "n": "a"}]}}`, []any{
+			[]any{map[string]any{"id": 1, "n": "a"}},
+			[]any{map[string]any{"id": 1, "n": "b"}},
+			[]any{map[string]any{"id": 1, "n": "a"}, map[string]any{"id": 2}},
+		}},
+		{"KeyedBy AllowExtra", KeyedBy("id", Obj("id", 1)).AllowExtra(), `{"$keyedBy": {"key": "id", "items": [{"id": 1}], "allowExtra": true}}`, []any{
+			[]any{map[string]any{"id": 1}, map[string]any{"id": 2}},
+			[]any{map[string]any{"id": 2}},
+		}},
+		{"Each", Each(Gt(0)), `{"$each": {"$gt": 0}}`, []any{[]any{1, 2}, []any{1, 0}}},
+		{"Some", Some(0), `{"$some": 0}`, []any{[]any{1, 0}, []any{1}}},
+		{"Count", Count(Obj("ok", true), Gte(2)), `{"$count": {"match": {"ok": true}, "gte": 2}}`, []any{
+			[]any{map[string]any{"ok": true}, map[string]any{"ok": true}},
+			[]any{map[string]any{"ok": true}, map[string]any{"ok": false}},
+		}},
+		{"OfType", OfType("string", "null"), `{"$type": ["string", "null"]}`, []any{"a", nil, 1}},
+		{"Format", Format("uuid"), `{"$format": "uuid"}`, []any{"123e4567-e89b-12d3-a456-426614174000", "nope"}},
+		{"After", After(ts), `{"$after": "2024-05-01T00:00:00Z"}`, []any{"2024-05-01T00:00:01Z", "2024-05-01T00:00:00Z"}},
+		{"AfterNow", AfterNow(), `{"$after": "now"}`, []any{"2024-05-01T00:00:01Z", "2024-04-30T23:59:59Z"}},
+		{"Before", Before(ts), `{"$before": "2024-05-01T00:00:00Z"}`, []any{"2024-04-30T23:59:59Z", "2024-05-01T00:00:00Z"}},
+		{"BeforeNow", BeforeNow(), `{"$before": "now"}`, []any{"2024-04-30T23:59:59Z", "2024-05-01T00:00:01Z"}},
+		{"TimeEq", TimeEq(ts), `{"$timeEq": "2024-05-01T00:00:00Z"}`, []any{"2024-05-01T02:00:00+02:00", "2024-05-01T00:00:01Z"}},
+		{"Within", Within(ts, time.Second), `{"$within": {"of": "2024-05-01T00:00:00Z", "delta": "1s"}}`, []any{"2024-05-01T00:00:01Z", "2024-05-01T00:00:02Z"}},
+		{"WithinNow", WithinNow(time.Second), `{"$within": {"of": "now", "delta": "1s"}}`, []any{"2024-04-30T23:59:59Z", "2024-05-01T00:00:02Z"}},
+		{"Approx", Approx(1, 0.1, 0), `{"$approx": {"value": 1, "abs": 0.1}}`, []any{1.05, 1.2}},
+		{"StartsWith", StartsWith("ab"), `{"$startsWith": "ab"}`, []any{"abc", "xab"}},
+		{"EndsWith", EndsWith("bc", TrimSpace()), `{"$endsWith": {"value": "bc", "trimSpace": true}}`, []any{"abc ", "abd"}},
+		{"ContainsString", ContainsString("b c", IgnoreCase(), CollapseWhitespace()), `{"$containsString": {"value": "b c", "ignoreCase": true, "collapseWhitespace": true}}`, []any{"AB  CD", "abcd"}},
+		{"EqualFold", EqualFold("abc"), `{"$ieq": "abc"}`, []any{"ABC", "abd"}},
+		{"ParseJSONOf", ParseJSONOf(Obj("id", 1)), `{"$parseJSON": {"id": 1}}`, []any{`{"id": 1, "n": 2}`, `{"id": 2}`, `{`}},
+		{"Base64Of", Base64Of("hi"), `{"$base64": "hi"}`, []any{"aGk=", "aGk", "aGo="}},
+		{"Base64Of AsJSON", Base64Of(Obj("ok", true)).AsJSON(), `{"$base64": {"value": {"ok": true}, "as": "json"}}`, []any{"eyJvayI6dHJ1ZX0=", "eyJvayI6ZmFsc2V9"}},
+		{"Base64Of AsBytes", Base64Of(Len(2)).AsBytes().URLEncoding(), `{"$base64": {"value": {"$length": 2}, "as": "bytes", "encoding": "url"}}`, []any{"aGk", "aA"}},
+		{"JWTOf", JWTOf(Obj("claims", Obj("sub", "42"))), `{"$jwt": {"claims": {"sub": "42"}}}`, []any{token, "a.b"}},
+		{"URLOf", URLOf(Obj("host", "example.com")), `{"$url": {"host": "example.com"}}`, []any{"https://example.com/x", "https://example.org/x"}},
+		{"Capture and Ref", Obj("a", Capture("x"), "b", Ref("x")), `{"a": {"$capture": "x"}, "b": {"$ref": "x"}}`, []any{map[string]any{"a": 1, "b": 1}, map[string]any{"a": 1, "b": 2}}},
+		{"Defs and Use", Defs(map[string]any{"m": Obj("a", 1)}, Obj("b", Use("m"))), `{"$defs": {"m": {"a": 1}}, "b": {"$use": "m"}}`, []any{map[string]any{"b": map[string]any{"a": 1}}, map[string]any{"b": map[string]any{"a": 2}}}},
+		{"Subset", Subset(Obj("a", 1)), `{"$subset": {"a": 1}}`, []any{map[string]any{"a": 1, "b": 2}, map[string]any{"b": 2}}},
+		{"Strict", Strict(Obj("a", 1)), `{"$strict": {"a": 1}}`, []any{map[string]any{"a": 1}, map[string]any{"a": 1, "b": 2}}},
+		{"InMode", InMode(0, ArrayUnordered, Arr(1, 2)), `{"$mode": {"arrays": "unordered", "value": [1, 2]}}`, []any{[]any{2, 1}, []any{1, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+" matches directive", func(t *testing.T) {
+			var decoded any
+			require.NoError(t, reg.Unmarshal([]byte(`{"v": `+tt.json+`}`), &decoded))
+			built := Obj("v", tt.rule)
+			actuals := []jwalk.Document{{}}
+			for _, a := range tt.actual {
+				actuals = append(actuals, jwalk.Document{{Key: "v", Value: a}})
+			}
+			outcomes := map[bool]bool{}
+			for _, act := range actuals {
+				want := tester.Test(decoded, act)
+				got := tester.Test(built, act)
+				assert.Equal(t, outcome(want), outcome(got), "actual %v: directive returned %v, constructor returned %v", act, want, got)
+				outcomes[want == nil] = true
+			}
+			assert.Len(t, outcomes, 2, "actual values should both pass and fail")
+		})
+	}
+
+	t.Run("invalid regex panics", func(t *testing.T) {
+		assert.Panics(t, func() { Regex("*invalid") })
+	})
+
+	t.Run("unknown type panics", func(t *testing.T) {
+		assert.Panics(t, func() { OfType("float") })
+	})
+
+	t.Run("count without comparator panics", func(t *testing.T) {
+		assert.Panics(t, func() { Count(Obj("active", true), nil) })
+	})
+
+	t.Run("negative within delta panics", func(t *testing.T) {
+		assert.Panics(t, func() { Within(time.Now(), -time.Second) })
+		assert.Panics(t, func() { WithinNow(-time.Second) })
+	})
+
+	t.Run("negative approx tolerance panics", func(t *testing.T) {
+		assert.Panics(t, func() { Approx(1, -0.1, 0) })
+		assert.Panics(t, func() { Approx(1, 0, -0.1) })
+	})
+
+	t.Run("not nil rejects nil like directive", func(t *testing.T) {
+		var decoded any
+		require.NoError(t, reg.Unmarshal([]byte(`{"$nil": false}`), &decoded))
+		want := decoded.(Rule).Test(newRC(&fakeTester{}), nil)
+		assert.Equal(t, want, NotNil().Test(newRC(&fakeTester{}), nil))
+		assert.NoError(t, NotNil().Test(newRC(&fakeTester{}), 1))
+	})
+}
+
+func TestBuilderExpectations(t *testing.T) {
+	exp := Obj(
+		"user", Obj(
+			"name", Regex("^Al"),
+			"age", AllOf(Gte(30), Lt(40)),
+			"tags", Len(Gte(1)),
+			"nickname", IfPresent(Ne("")),
+			"deleted", Absent(),
+		),
+	)
+
+	t.Run("matching actual succeeds", func(t *testing.T) {
+		act := jwalk.Document{{Key: "user", Value: jwalk.Document{
+			{Key: "name", Value: "Alice"},
+			{Key: "age", Value: 31},
+			{Key: "tags", Value: jwalk.Array{"blue"}},
+		}}}
+		assert.NoError(t, New().Test(exp, act))
+	})
+
+	t.Run("length rule failure returns error", func(t *testing.T) {
+		act := jwalk.Document{{Key: "user", Value: jwalk.Document{
+			{Key: "name", Value: "Alice"},
+			{Key: "age", Value: 31},
+			{Key: "tags", Value: jwalk.Array{}},
+		}}}
+		assert.Error(t, New().Test(exp, act))
+	})
+}
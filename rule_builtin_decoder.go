@@ -2,8 +2,11 @@ package testequals
 
 import (
 	"errors"
+	"fmt"
 	"regexp"
+	"time"
 
+	"github.com/calumari/jwalk"
 	"github.com/go-json-experiment/json"
 	"github.com/go-json-experiment/json/jsontext"
 )
@@ -69,65 +72,43 @@ func unmarshalElementsMatch(dec *jsontext.Decoder) (*ElementsMatch, error) {
 	return &ElementsMatch{expected}, nil
 }
 
-func unmarshalLength(dec *jsontext.Decoder) (*Length, error) {
-	type aux struct {
-		Eq  *int `json:"eq,omitempty"`
-		Lt  *int `json:"lt,omitempty"`
-		Lte *int `json:"lte,omitempty"`
-		Gt  *int `json:"gt,omitempty"`
-		Gte *int `json:"gte,omitempty"`
-	}
-
-	c := &Length{}
-
-	setIntPtr := func(p **int, v int) {
-		*p = new(int)
-		**p = v
-	}
+// bounds is the object payload of "$length" and the bounds of "$count".
+type bounds struct {
+	Eq  *int `json:"eq,omitempty"`
+	Lt  *int `json:"lt,omitempty"`
+	Lte *int `json:"lte,omitempty"`
+	Gt  *int `json:"gt,omitempty"`
+	Gte *int `json:"gte,omitempty"`
+}
 
-	validateInt := func(n int) error {
-		if n < 0 {
-			return errors.New("length must be non-negative")
+// validate requires at least one non-negative bound and rejects eq combined
+// with other bounds.
+func (b *bounds) validate(directive string) error {
+	set := 0
+	for _, p := range []*int{b.Eq, b.Lt, b.Lte, b.Gt, b.Gte} {
+		if p == nil {
+			continue
+		}
+		if *p < 0 {
+			return errors.New(directive + " must be non-negative")
 		}
-		return nil
+		set++
 	}
+	if set == 0 {
+		return errors.New("no " + directive + " comparator provided")
+	}
+	if b.Eq != nil && set > 1 {
+		return errors.New("eq cannot be combined with other " + directive + " comparators")
+	}
+	return nil
+}
 
+func unmarshalLength(dec *jsontext.Decoder) (*Length, error) {
+	var b bounds
 	if dec.PeekKind() == '{' {
-		var a aux
-		if err := json.UnmarshalDecode(dec, &a); err != nil {
+		if err := json.UnmarshalDecode(dec, &b); err != nil {
 			return nil, err
 		}
-
-		if a.Eq != nil {
-			if err := validateInt(*a.Eq); err != nil {
-				return nil, err
-			}
-			setIntPtr(&c.eq, *a.Eq)
-		}
-		if a.Lt != nil {
-			if err := validateInt(*a.Lt); err != nil {
-				return nil, err
-			}
-			setIntPtr(&c.lt, *a.Lt)
-		}
-		if a.Lte != nil {
-			if err := validateInt(*a.Lte); err != nil {
-				return nil, err
-			}
-			setIntPtr(&c.lte, *a.Lte)
-		}
-		if a.Gt != nil {
-			if err := validateInt(*a.Gt); err != nil {
-				return nil, err
-			}
-			setIntPtr(&c.gt, *a.Gt)
-		}
-		if a.Gte != nil {
-			if err := validateInt(*a.Gte); err != nil {
-				return nil, err
-			}
-			setIntPtr(&c.gte, *a.Gte)
-		}
 	} else {
 		var num float64
 		if err := json.UnmarshalDecode(dec, &num); err != nil {
@@ -136,21 +117,13 @@ func unmarshalLength(dec *jsontext.Decoder) (*Length, error) {
 		if num != float64(int(num)) {
 			return nil, errors.New("length value must be integer")
 		}
-		if num < 0 {
-			return nil, errors.New("length must be non-negative")
-		}
 		i := int(num)
-		c.eq = &i
+		b.Eq = &i
 	}
-
-	if c.eq == nil && c.lt == nil && c.lte == nil && c.gt == nil && c.gte == nil {
-		return nil, errors.New("no length comparator provided")
-	}
-	if c.eq != nil && (c.lt != nil || c.lte != nil || c.gt != nil || c.gte != nil) {
-		return nil, errors.New("eq cannot be combined with other length comparators")
+	if err := b.validate("length"); err != nil {
+		return nil, err
 	}
-
-	return c, nil
+	return &Length{eq: b.Eq, lt: b.Lt, lte: b.Lte, gt: b.Gt, gte: b.Gte}, nil
 }
 
 func unmarshalEmpty(dec *jsontext.Decoder) (*Empty, error) {
@@ -241,6 +214,404 @@ func unmarshalNot(dec *jsontext.Decoder) (*Not, error) {
 	return &Not{v}, nil
 }
 
+// unmarshalExists decodes a boolean payload. expected is the presence asserted
+// by a true payload, so "$exists" uses true and "$absent" uses false.
+func unmarshalExists(expected bool) func(dec *jsontext.Decoder) (*Exists, error) {
+	return func(dec *jsontext.Decoder) (*Exists, error) {
+		var want bool
+		if err := json.UnmarshalDecode(dec, &want); err != nil {
+			return nil, err
+		}
+		return &Exists{want: want == expected}, nil
+	}
+}
+
+func unmarshalOptional(dec *jsontext.Decoder) (*Optional, error) {
+	var v any
+	if err := json.UnmarshalDecode(dec, &v); err != nil {
+		return nil, err
+	}
+	return &Optional{v}, nil
+}
+
+func unmarshalSubset(dec *jsontext.Decoder) (*Mode, error) {
+	var v any
+	if err := json.UnmarshalDecode(dec, &v); err != nil {
+		return nil, err
+	}
+	return &Mode{objects: ObjectSubset, expected: v}, nil
+}
+
+func unmarshalStrict(dec *jsontext.Decoder) (*Mode, error) {
+	var v any
+	if err := json.UnmarshalDecode(dec, &v); err != nil {
+		return nil, err
+	}
+	return &Mode{objects: ObjectStrict, arrays: ArrayStrict, expected: v}, nil
+}
+
+func unmarshalMode(dec *jsontext.Decoder) (*Mode, error) {
+	type aux struct {
+		Objects ObjectMode `json:"objects,omitempty"`
+		Arrays  ArrayMode  `json:"arrays,omitempty"`
+		Value   *any       `json:"value"`
+	}
+	var a aux
+	if err := json.UnmarshalDecode(dec, &a); err != nil {
+		return nil, err
+	}
+	if a.Objects == 0 && a.Arrays == 0 {
+		return nil, errors.New("mode directive requires objects or arrays")
+	}
+	if a.Value == nil {
+		return nil, errors.New("mode directive requires value")
+	}
+	return &Mode{objects: a.Objects, arrays: a.Arrays, expected: *a.Value}, nil
+}
+
+func unmarshalContains(dec *jsontext.Decoder) (*ArrayContains, error) {
+	arr, err := decodeArray(dec)
+	if err != nil {
+		return nil, err
+	}
+	return &ArrayContains{arr}, nil
+}
+
+func unmarshalContainsInOrder(dec *jsontext.Decoder) (*ArrayContainsInOrder, error) {
+	arr, err := decodeArray(dec)
+	if err != nil {
+		return nil, err
+	}
+	return &ArrayContainsInOrder{arr}, nil
+}
+
+func unmarshalKeyedBy(dec *jsontext.Decoder) (*KeyedArray, error) {
+	type aux struct {
+		Key        any   `json:"key"`
+		Items      []any `json:"items"`
+		AllowExtra bool  `json:"allowExtra"`
+	}
+	var a aux
+	if err := json.UnmarshalDecode(dec, &a); err != nil {
+		return nil, err
+	}
+	c := &KeyedArray{items: a.Items, allowExtra: a.AllowExtra}
+	errKey := errors.New("keyedBy key must be a string or an array of strings")
+	if k, ok := a.Key.(string); ok {
+		c.keys = []string{k}
+	} else if arr, ok := asArray(a.Key); ok {
+		for _, f := range arr {
+			s, ok := f.(string)
+			if !ok {
+				return nil, errKey
+			}
+			c.keys = append(c.keys, s)
+		}
+	} else {
+		return nil, errKey
+	}
+	if a.Items == nil {
+		return nil, errors.New("keyedBy directive requires items")
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func unmarshalEach(dec *jsontext.Decoder) (*EachElement, error) {
+	var v any
+	if err := json.UnmarshalDecode(dec, &v); err != nil {
+		return nil, err
+	}
+	return &EachElement{v}, nil
+}
+
+func unmarshalSome(dec *jsontext.Decoder) (*SomeElement, error) {
+	var v any
+	if err := json.UnmarshalDecode(dec, &v); err != nil {
+		return nil, err
+	}
+	return &SomeElement{v}, nil
+}
+
+func unmarshalCount(dec *jsontext.Decoder) (*CountElements, error) {
+	type aux struct {
+		Match *any `json:"match"`
+		bounds
+	}
+	var a aux
+	if err := json.UnmarshalDecode(dec, &a); err != nil {
+		return nil, err
+	}
+	if a.Match == nil {
+		return nil, errors.New("count directive requires match")
+	}
+	if err := a.validate("count"); err != nil {
+		return nil, err
+	}
+	return &CountElements{match: *a.Match, eq: a.Eq, lt: a.Lt, lte: a.Lte, gt: a.Gt, gte: a.Gte}, nil
+}
+
+func unmarshalType(dec *jsontext.Decoder) (*HasType, error) {
+	c := &HasType{}
+	if dec.PeekKind() == '[' {
+		if err := json.UnmarshalDecode(dec, &c.types); err != nil {
+			return nil, err
+		}
+	} else {
+		var name string
+		if err := json.UnmarshalDecode(dec, &name); err != nil {
+			return nil, errors.New("type directive expects a type name or an array of names")
+		}
+		c.types = []string{name}
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func unmarshalFormat(dec *jsontext.Decoder) (*MatchFormat, error) {
+	var name string
+	if err := json.UnmarshalDecode(dec, &name); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("format directive requires a format name")
+	}
+	return &MatchFormat{name}, nil
+}
+
+func unmarshalTime(op string) func(*jsontext.Decoder) (*timeCompare, error) {
+	return func(dec *jsontext.Decoder) (*timeCompare, error) {
+		var s string
+		if err := json.UnmarshalDecode(dec, &s); err != nil {
+			return nil, err
+		}
+		ref, err := parseTimeRef(s)
+		if err != nil {
+			return nil, err
+		}
+		return &timeCompare{op: op, ref: ref}, nil
+	}
+}
+
+func unmarshalWithin(dec *jsontext.Decoder) (*timeCompare, error) {
+	type aux struct {
+		Of    string `json:"of"`
+		Delta string `json:"delta"`
+	}
+	var a aux
+	if err := json.UnmarshalDecode(dec, &a); err != nil {
+		return nil, err
+	}
+	if a.Of == "" || a.Delta == "" {
+		return nil, errors.New("within directive requires of and delta")
+	}
+	ref, err := parseTimeRef(a.Of)
+	if err != nil {
+		return nil, err
+	}
+	delta, err := time.ParseDuration(a.Delta)
+	if err != nil {
+		return nil, err
+	}
+	if delta < 0 {
+		return nil, errors.New("within delta must be non-negative")
+	}
+	return &timeCompare{op: "within", ref: ref, delta: delta}, nil
+}
+
+func unmarshalApprox(dec *jsontext.Decoder) (*ApproxEqual, error) {
+	type aux struct {
+		Value *float64 `json:"value"`
+		Abs   *float64 `json:"abs"`
+		Rel   *float64 `json:"rel"`
+	}
+	var a aux
+	if err := json.UnmarshalDecode(dec, &a); err != nil {
+		return nil, err
+	}
+	if a.Value == nil {
+		return nil, errors.New("approx directive requires value")
+	}
+	if a.Abs == nil && a.Rel == nil {
+		return nil, errors.New("approx directive requires abs or rel")
+	}
+	c := &ApproxEqual{value: *a.Value}
+	if a.Abs != nil {
+		c.tol.abs = *a.Abs
+	}
+	if a.Rel != nil {
+		c.tol.rel = *a.Rel
+	}
+	if c.tol.abs < 0 || c.tol.rel < 0 {
+		return nil, errors.New("approx tolerances must be non-negative")
+	}
+	return c, nil
+}
+
+// unmarshalStringCompare decodes a string directive, given either as the
+// string to compare with or as an object holding it under "value" along with
+// normalization flags.
+func unmarshalStringCompare(op string) func(*jsontext.Decoder) (*stringCompare, error) {
+	return func(dec *jsontext.Decoder) (*stringCompare, error) {
+		c := &stringCompare{op: op}
+		if dec.PeekKind() == '{' {
+			type aux struct {
+				Value              *string `json:"value"`
+				IgnoreCase         bool    `json:"ignoreCase"`
+				TrimSpace          bool    `json:"trimSpace"`
+				CollapseWhitespace bool    `json:"collapseWhitespace"`
+			}
+			var a aux
+			if err := json.UnmarshalDecode(dec, &a); err != nil {
+				return nil, err
+			}
+			if a.Value == nil {
+				return nil, fmt.Errorf("%s directive requires value", op)
+			}
+			c.value = *a.Value
+			c.norm = stringNorm{ignoreCase: a.IgnoreCase, trimSpace: a.TrimSpace, collapseWhitespace: a.CollapseWhitespace}
+		} else if err := json.UnmarshalDecode(dec, &c.value); err != nil {
+			return nil, err
+		}
+		if op == "ieq" {
+			c.norm.ignoreCase = true
+		}
+		return c, nil
+	}
+}
+
+// unmarshalParseJSON decodes the nested expectation and keeps the decoder's
+// unmarshalers, which carry the jwalk registry, to decode actual values with.
+func unmarshalParseJSON(dec *jsontext.Decoder) (*ParseJSON, error) {
+	var expected any
+	if err := json.UnmarshalDecode(dec, &expected); err != nil {
+		return nil, err
+	}
+	unmarshalers, _ := json.GetOption(dec.Options(), json.WithUnmarshalers)
+	return &ParseJSON{expected: expected, unmarshalers: unmarshalers}, nil
+}
+
+// unmarshalBase64 decodes either the expectation for the decoded string or an
+// object holding it under "value" along with "as" and "encoding".
+func unmarshalBase64(dec *jsontext.Decoder) (*DecodeBase64, error) {
+	unmarshalers, _ := json.GetOption(dec.Options(), json.WithUnmarshalers)
+	c := &DecodeBase64{as: "string", unmarshalers: unmarshalers}
+	if dec.PeekKind() != '{' {
+		if err := json.UnmarshalDecode(dec, &c.expected); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	type aux struct {
+		Value    *any   `json:"value"`
+		As       string `json:"as"`
+		Encoding string `json:"encoding"`
+	}
+	var a aux
+	if err := json.UnmarshalDecode(dec, &a); err != nil {
+		return nil, err
+	}
+	if a.Value == nil {
+		return nil, errors.New("base64 directive requires value")
+	}
+	c.expected = *a.Value
+	switch a.As {
+	case "", "string":
+	case "bytes", "json":
+		c.as = a.As
+	default:
+		return nil, fmt.Errorf("unknown base64 target %q", a.As)
+	}
+	switch a.Encoding {
+	case "", "std":
+	case "url":
+		c.url = true
+	default:
+		return nil, fmt.Errorf("unknown base64 encoding %q", a.Encoding)
+	}
+	return c, nil
+}
+
+func unmarshalJWT(dec *jsontext.Decoder) (*DecodeJWT, error) {
+	var expected any
+	if err := json.UnmarshalDecode(dec, &expected); err != nil {
+		return nil, err
+	}
+	unmarshalers, _ := json.GetOption(dec.Options(), json.WithUnmarshalers)
+	return &DecodeJWT{expected: expected, unmarshalers: unmarshalers}, nil
+}
+
+func unmarshalURL(dec *jsontext.Decoder) (*ParseURL, error) {
+	var expected any
+	if err := json.UnmarshalDecode(dec, &expected); err != nil {
+		return nil, err
+	}
+	return &ParseURL{expected}, nil
+}
+
+func unmarshalCapture(dec *jsontext.Decoder) (*CaptureValue, error) {
+	var name string
+	if err := json.UnmarshalDecode(dec, &name); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("capture directive requires a name")
+	}
+	return &CaptureValue{name}, nil
+}
+
+func unmarshalRef(dec *jsontext.Decoder) (*RefValue, error) {
+	var name string
+	if err := json.UnmarshalDecode(dec, &name); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("ref directive requires a name")
+	}
+	return &RefValue{name}, nil
+}
+
+// unmarshalDefs decodes the definitions and then the remaining members of the
+// enclosing document, which jwalk would otherwise skip after a directive, as
+// the expectation they apply to.
+func unmarshalDefs(dec *jsontext.Decoder) (*Definitions, error) {
+	if dec.PeekKind() != '{' {
+		return nil, errors.New("defs directive requires an object of definitions")
+	}
+	var defs map[string]any
+	if err := json.UnmarshalDecode(dec, &defs); err != nil {
+		return nil, err
+	}
+	rest := jwalk.Document{}
+	for dec.PeekKind() == '"' {
+		var k string
+		if err := json.UnmarshalDecode(dec, &k); err != nil {
+			return nil, err
+		}
+		var v any
+		if err := json.UnmarshalDecode(dec, &v); err != nil {
+			return nil, err
+		}
+		rest = append(rest, jwalk.Entry{Key: k, Value: v})
+	}
+	return &Definitions{defs: defs, expected: rest}, nil
+}
+
+func unmarshalUse(dec *jsontext.Decoder) (*UseDefinition, error) {
+	var name string
+	if err := json.UnmarshalDecode(dec, &name); err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, errors.New("use directive requires a definition name")
+	}
+	return &UseDefinition{name}, nil
+}
+
 func decodeNumber(dec *jsontext.Decoder) (float64, error) {
 	var n any
 	if err := json.UnmarshalDecode(dec, &n); err != nil {
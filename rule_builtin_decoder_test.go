@@ -3,6 +3,7 @@ package testequals
 import (
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/calumari/jwalk"
 	"github.com/go-json-experiment/json"
@@ -383,6 +384,570 @@ func Test_unmarshalNot(t *testing.T) {
 	})
 }
 
+func Test_unmarshalExists(t *testing.T) {
+	t.Run("exists true succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`true`))
+		got, err := unmarshalExists(true)(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &Exists{want: true}, got)
+	})
+
+	t.Run("exists false succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`false`))
+		got, err := unmarshalExists(true)(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &Exists{want: false}, got)
+	})
+
+	t.Run("absent true succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`true`))
+		got, err := unmarshalExists(false)(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &Exists{want: false}, got)
+	})
+
+	t.Run("non-bool returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"yes"`))
+		got, err := unmarshalExists(true)(dec)
+		assert.Error(t, err)
+		assert.Nil(t, got)
+	})
+}
+
+func Test_unmarshalOptional(t *testing.T) {
+	t.Run("basic value succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`42`))
+		got, err := unmarshalOptional(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &Optional{expected: float64(42)}, got)
+	})
+
+	t.Run("nested directive succeeds", func(t *testing.T) {
+		reg, err := jwalk.NewRegistry(jwalk.WithDirective(TestOptionalDirective), jwalk.WithDirective(TestMatchStringDirective))
+		require.NoError(t, err)
+		var got any
+		err = json.UnmarshalRead(strings.NewReader(`{"$optional": {"$regex": "^a"}}`), &got, json.WithUnmarshalers(jwalk.Unmarshalers(reg)))
+		require.NoError(t, err)
+		require.IsType(t, &Optional{}, got)
+		assert.IsType(t, &MatchString{}, got.(*Optional).expected)
+	})
+}
+
+func Test_unmarshalSubset(t *testing.T) {
+	t.Run("basic value succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"a": 1}`))
+		got, err := unmarshalSubset(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &Mode{objects: ObjectSubset, expected: map[string]any{"a": float64(1)}}, got)
+	})
+}
+
+func Test_unmarshalStrict(t *testing.T) {
+	t.Run("basic value succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`[1]`))
+		got, err := unmarshalStrict(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &Mode{objects: ObjectStrict, arrays: ArrayStrict, expected: []any{float64(1)}}, got)
+	})
+}
+
+func Test_unmarshalMode(t *testing.T) {
+	t.Run("objects and arrays succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"objects": "strict", "arrays": "unorderedSubset", "value": 1}`))
+		got, err := unmarshalMode(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &Mode{objects: ObjectStrict, arrays: ArrayUnorderedSubset, expected: float64(1)}, got)
+	})
+
+	t.Run("arrays only succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"arrays": "orderedPrefix", "value": []}`))
+		got, err := unmarshalMode(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &Mode{arrays: ArrayOrderedPrefix, expected: []any{}}, got)
+	})
+
+	t.Run("nested directive succeeds", func(t *testing.T) {
+		reg, err := jwalk.NewRegistry(jwalk.WithDirective(TestModeDirective), jwalk.WithDirective(TestMatchStringDirective))
+		require.NoError(t, err)
+		var got any
+		err = json.UnmarshalRead(strings.NewReader(`{"$mode": {"arrays": "unordered", "value": {"$regex": "^a"}}}`), &got, json.WithUnmarshalers(jwalk.Unmarshalers(reg)))
+		require.NoError(t, err)
+		require.IsType(t, &Mode{}, got)
+		assert.IsType(t, &MatchString{}, got.(*Mode).expected)
+	})
+
+	t.Run("unknown mode returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"arrays": "sorted", "value": []}`))
+		_, err := unmarshalMode(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("no mode returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"value": []}`))
+		_, err := unmarshalMode(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing value returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"arrays": "unordered"}`))
+		_, err := unmarshalMode(dec)
+		assert.EqualError(t, err, "mode directive requires value")
+	})
+}
+
+func Test_unmarshalContains(t *testing.T) {
+	t.Run("array succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`[1, "a"]`))
+		got, err := unmarshalContains(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &ArrayContains{expected: []any{float64(1), "a"}}, got)
+	})
+
+	t.Run("non-array returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`1`))
+		_, err := unmarshalContains(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalContainsInOrder(t *testing.T) {
+	t.Run("array succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`["a", "b"]`))
+		got, err := unmarshalContainsInOrder(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &ArrayContainsInOrder{expected: []any{"a", "b"}}, got)
+	})
+
+	t.Run("non-array returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{}`))
+		_, err := unmarshalContainsInOrder(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalKeyedBy(t *testing.T) {
+	decode := func(src string) (any, error) {
+		reg, err := jwalk.NewRegistry(jwalk.WithDirective(TestKeyedByDirective))
+		require.NoError(t, err)
+		var got any
+		err = json.UnmarshalRead(strings.NewReader(src), &got, json.WithUnmarshalers(jwalk.Unmarshalers(reg)))
+		return got, err
+	}
+
+	t.Run("single key succeeds", func(t *testing.T) {
+		got, err := decode(`{"$keyedBy": {"key": "id", "items": [{"id": 1, "name": "a"}]}}`)
+		require.NoError(t, err)
+		assert.Equal(t, &KeyedArray{
+			keys:  []string{"id"},
+			items: []any{jwalk.Document{{Key: "id", Value: float64(1)}, {Key: "name", Value: "a"}}},
+		}, got)
+	})
+
+	t.Run("composite key with extra succeeds", func(t *testing.T) {
+		got, err := decode(`{"$keyedBy": {"key": ["tenant", "id"], "items": [], "allowExtra": true}}`)
+		require.NoError(t, err)
+		assert.Equal(t, &KeyedArray{keys: []string{"tenant", "id"}, items: []any{}, allowExtra: true}, got)
+	})
+
+	t.Run("invalid key returns error", func(t *testing.T) {
+		_, err := decode(`{"$keyedBy": {"key": 1, "items": []}}`)
+		assert.Error(t, err)
+		_, err = decode(`{"$keyedBy": {"key": [1], "items": []}}`)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing items returns error", func(t *testing.T) {
+		_, err := decode(`{"$keyedBy": {"key": "id"}}`)
+		assert.Error(t, err)
+	})
+
+	t.Run("item without key returns error", func(t *testing.T) {
+		_, err := decode(`{"$keyedBy": {"key": "id", "items": [{"name": "a"}]}}`)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalEach(t *testing.T) {
+	t.Run("nested directive succeeds", func(t *testing.T) {
+		reg, err := jwalk.NewRegistry(jwalk.WithDirective(TestEachDirective), jwalk.WithDirective(TestGreaterThanDirective))
+		require.NoError(t, err)
+		var got any
+		err = json.UnmarshalRead(strings.NewReader(`{"$each": {"price": {"$gt": 0}}}`), &got, json.WithUnmarshalers(jwalk.Unmarshalers(reg)))
+		require.NoError(t, err)
+		require.IsType(t, &EachElement{}, got)
+		assert.Equal(t, jwalk.Document{{Key: "price", Value: &numericCompare{op: "gt", ref: 0}}}, got.(*EachElement).expected)
+	})
+}
+
+func Test_unmarshalSome(t *testing.T) {
+	t.Run("basic value succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"a"`))
+		got, err := unmarshalSome(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &SomeElement{expected: "a"}, got)
+	})
+}
+
+func Test_unmarshalCount(t *testing.T) {
+	t.Run("match with bounds succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"match": "a", "gte": 2, "lt": 5}`))
+		got, err := unmarshalCount(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &CountElements{match: "a", gte: toPtr(2), lt: toPtr(5)}, got)
+	})
+
+	t.Run("missing match returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"eq": 1}`))
+		_, err := unmarshalCount(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("no comparator returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"match": 1}`))
+		_, err := unmarshalCount(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("eq combined returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"match": 1, "eq": 1, "gt": 0}`))
+		_, err := unmarshalCount(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("negative bound returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"match": 1, "gte": -1}`))
+		_, err := unmarshalCount(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalType(t *testing.T) {
+	t.Run("single name succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"string"`))
+		got, err := unmarshalType(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &HasType{types: []string{"string"}}, got)
+	})
+
+	t.Run("array of names succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`["integer", "null"]`))
+		got, err := unmarshalType(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &HasType{types: []string{"integer", "null"}}, got)
+	})
+
+	t.Run("unknown name returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"float"`))
+		_, err := unmarshalType(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("empty array returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`[]`))
+		_, err := unmarshalType(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("non-string payload returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`1`))
+		_, err := unmarshalType(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalFormat(t *testing.T) {
+	t.Run("name succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"uuid"`))
+		got, err := unmarshalFormat(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &MatchFormat{name: "uuid"}, got)
+	})
+
+	t.Run("empty name returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`""`))
+		_, err := unmarshalFormat(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("non-string returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`1`))
+		_, err := unmarshalFormat(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalTime(t *testing.T) {
+	t.Run("timestamp succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"2024-05-01T12:00:00+02:00"`))
+		got, err := unmarshalTime("after")(dec)
+		require.NoError(t, err)
+		assert.Equal(t, "after", got.op)
+		assert.True(t, got.ref.t.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("now succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"now"`))
+		got, err := unmarshalTime("before")(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &timeCompare{op: "before", ref: timeRef{now: true}}, got)
+	})
+
+	t.Run("invalid timestamp returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"2024-05-01"`))
+		_, err := unmarshalTime("after")(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalWithin(t *testing.T) {
+	t.Run("of now succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"of": "now", "delta": "5s"}`))
+		got, err := unmarshalWithin(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &timeCompare{op: "within", ref: timeRef{now: true}, delta: 5 * time.Second}, got)
+	})
+
+	t.Run("missing delta returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"of": "now"}`))
+		_, err := unmarshalWithin(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid delta returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"of": "now", "delta": "5 seconds"}`))
+		_, err := unmarshalWithin(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("negative delta returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"of": "now", "delta": "-1s"}`))
+		_, err := unmarshalWithin(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalApprox(t *testing.T) {
+	t.Run("value with tolerances succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"value": 3.14, "abs": 0.001, "rel": 0.01}`))
+		got, err := unmarshalApprox(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &ApproxEqual{value: 3.14, tol: tolerance{abs: 0.001, rel: 0.01}}, got)
+	})
+
+	t.Run("missing value returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"abs": 0.001}`))
+		_, err := unmarshalApprox(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("missing tolerance returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"value": 1}`))
+		_, err := unmarshalApprox(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("negative tolerance returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"value": 1, "rel": -0.1}`))
+		_, err := unmarshalApprox(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalStringCompare(t *testing.T) {
+	t.Run("string payload succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"not found"`))
+		got, err := unmarshalStringCompare("containsString")(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &stringCompare{op: "containsString", value: "not found"}, got)
+	})
+
+	t.Run("object payload succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"value": "Err", "ignoreCase": true, "trimSpace": true, "collapseWhitespace": true}`))
+		got, err := unmarshalStringCompare("startsWith")(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &stringCompare{op: "startsWith", value: "Err", norm: stringNorm{ignoreCase: true, trimSpace: true, collapseWhitespace: true}}, got)
+	})
+
+	t.Run("ieq ignores case succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"A@B.com"`))
+		got, err := unmarshalStringCompare("ieq")(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &stringCompare{op: "ieq", value: "A@B.com", norm: stringNorm{ignoreCase: true}}, got)
+	})
+
+	t.Run("object without value returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"ignoreCase": true}`))
+		_, err := unmarshalStringCompare("endsWith")(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("non-string returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`1`))
+		_, err := unmarshalStringCompare("endsWith")(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalParseJSON(t *testing.T) {
+	t.Run("keeps registry unmarshalers succeeds", func(t *testing.T) {
+		reg, err := NewRegistry()
+		require.NoError(t, err)
+		var got any
+		err = json.UnmarshalRead(strings.NewReader(`{"$parseJSON": {"id": {"$gt": 0}}}`), &got, json.WithUnmarshalers(jwalk.Unmarshalers(reg)))
+		require.NoError(t, err)
+		require.IsType(t, &ParseJSON{}, got)
+		pj := got.(*ParseJSON)
+		assert.Equal(t, jwalk.Document{{Key: "id", Value: &numericCompare{op: "gt", ref: 0}}}, pj.expected)
+		assert.NotNil(t, pj.unmarshalers)
+	})
+}
+
+func Test_unmarshalBase64(t *testing.T) {
+	t.Run("bare expectation succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"hello"`))
+		got, err := unmarshalBase64(dec)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", got.expected)
+		assert.Equal(t, "string", got.as)
+		assert.False(t, got.url)
+	})
+
+	t.Run("object payload succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"value": {"id": 1}, "as": "json", "encoding": "url"}`))
+		got, err := unmarshalBase64(dec)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"id": 1.0}, got.expected)
+		assert.Equal(t, "json", got.as)
+		assert.True(t, got.url)
+	})
+
+	t.Run("keeps registry unmarshalers succeeds", func(t *testing.T) {
+		reg, err := NewRegistry()
+		require.NoError(t, err)
+		var got any
+		require.NoError(t, reg.Unmarshal([]byte(`{"$base64": {"value": {"id": 1}, "as": "json"}}`), &got))
+		require.IsType(t, &DecodeBase64{}, got)
+		assert.NotNil(t, got.(*DecodeBase64).unmarshalers)
+	})
+
+	t.Run("missing value returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"as": "bytes"}`))
+		_, err := unmarshalBase64(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown target returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"value": "x", "as": "xml"}`))
+		_, err := unmarshalBase64(dec)
+		assert.Error(t, err)
+	})
+
+	t.Run("unknown encoding returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"value": "x", "encoding": "hex"}`))
+		_, err := unmarshalBase64(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalJWT(t *testing.T) {
+	t.Run("expectation succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"claims": {"sub": "42"}}`))
+		got, err := unmarshalJWT(dec)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"claims": map[string]any{"sub": "42"}}, got.expected)
+	})
+
+	t.Run("keeps registry unmarshalers succeeds", func(t *testing.T) {
+		reg, err := NewRegistry()
+		require.NoError(t, err)
+		var got any
+		require.NoError(t, reg.Unmarshal([]byte(`{"$jwt": {"claims": {"sub": "42"}}}`), &got))
+		require.IsType(t, &DecodeJWT{}, got)
+		assert.NotNil(t, got.(*DecodeJWT).unmarshalers)
+	})
+}
+
+func Test_unmarshalURL(t *testing.T) {
+	t.Run("expectation succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"host": "example.com"}`))
+		got, err := unmarshalURL(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &ParseURL{expected: map[string]any{"host": "example.com"}}, got)
+	})
+}
+
+func Test_unmarshalCapture(t *testing.T) {
+	t.Run("name succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"orderId"`))
+		got, err := unmarshalCapture(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &CaptureValue{name: "orderId"}, got)
+	})
+
+	t.Run("empty name returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`""`))
+		_, err := unmarshalCapture(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalRef(t *testing.T) {
+	t.Run("name succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"orderId"`))
+		got, err := unmarshalRef(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &RefValue{name: "orderId"}, got)
+	})
+
+	t.Run("non-string returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`1`))
+		_, err := unmarshalRef(dec)
+		assert.Error(t, err)
+	})
+}
+
+func Test_unmarshalDefs(t *testing.T) {
+	t.Run("consumes enclosing document succeeds", func(t *testing.T) {
+		reg, err := NewRegistry()
+		require.NoError(t, err)
+		var got any
+		err = json.UnmarshalRead(strings.NewReader(`{"$defs": {"id": {"$gt": 0}}, "a": {"$use": "id"}, "b": 2}`), &got, json.WithUnmarshalers(jwalk.Unmarshalers(reg)))
+		require.NoError(t, err)
+		assert.Equal(t, &Definitions{
+			defs:     map[string]any{"id": &numericCompare{op: "gt", ref: 0}},
+			expected: jwalk.Document{{Key: "a", Value: &UseDefinition{name: "id"}}, {Key: "b", Value: 2.0}},
+		}, got)
+	})
+
+	t.Run("defs only succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`{"a": 1}`))
+		got, err := unmarshalDefs(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &Definitions{defs: map[string]any{"a": 1.0}, expected: jwalk.Document{}}, got)
+	})
+
+	t.Run("non-object returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`[]`))
+		_, err := unmarshalDefs(dec)
+		assert.EqualError(t, err, "defs directive requires an object of definitions")
+	})
+}
+
+func Test_unmarshalUse(t *testing.T) {
+	t.Run("name succeeds", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`"money"`))
+		got, err := unmarshalUse(dec)
+		require.NoError(t, err)
+		assert.Equal(t, &UseDefinition{name: "money"}, got)
+	})
+
+	t.Run("empty name returns error", func(t *testing.T) {
+		dec := jsontext.NewDecoder(strings.NewReader(`""`))
+		_, err := unmarshalUse(dec)
+		assert.Error(t, err)
+	})
+}
+
 func Test_decodeNumber(t *testing.T) {
 	t.Run("int value succeeds", func(t *testing.T) {
 		dec := jsontext.NewDecoder(strings.NewReader(`5`))
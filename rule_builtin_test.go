@@ -4,6 +4,7 @@ import (
 	"reflect"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/calumari/jwalk"
 	"github.com/stretchr/testify/assert"
@@ -18,11 +19,13 @@ func (f *fakeTester) Test(e, a any) error {
 		return r.Test(&RuleContext{runner: f, inner: &cmpCtx{}}, a)
 	}
 	if !reflect.DeepEqual(e, a) {
-		return mismatch(nil, "values differ")
+		return newMismatch(ValueMismatch, e, a, "values differ")
 	}
 	return nil
 }
 
+func (f *fakeTester) testNested(_ *cmpCtx, e, a any) error { return f.Test(e, a) }
+
 func newRC(r testRunner) *RuleContext { return &RuleContext{runner: r, inner: &cmpCtx{}} }
 
 func TestEqualRule(t *testing.T) {
@@ -79,6 +82,18 @@ func TestEqualRule(t *testing.T) {
 		r := &Equal{expected: 5}
 		assert.NoError(t, r.Test(newRC(ft), 5))
 	})
+
+	t.Run("Document absent rule for missing key succeeds", func(t *testing.T) {
+		ft := &fakeTester{}
+		r := &Equal{expected: jwalk.Document{{Key: "a", Value: 1}, {Key: "x", Value: &Exists{want: false}}}}
+		assert.NoError(t, r.Test(newRC(ft), jwalk.Document{{Key: "a", Value: 1}}))
+	})
+
+	t.Run("Document absent rule for missing key returns error", func(t *testing.T) {
+		ft := &fakeTester{}
+		r := &Equal{expected: jwalk.Document{{Key: "x", Value: &Exists{want: true}}}}
+		assert.Error(t, r.Test(newRC(ft), jwalk.Document{}))
+	})
 }
 
 func TestNilRule(t *testing.T) {
@@ -165,6 +180,12 @@ func TestMatchStringRule(t *testing.T) {
 		re := &MatchString{re: regexp.MustCompile("^abc")}
 		assert.Error(t, re.Test(newRC(&fakeTester{}), "zzz"))
 	})
+	t.Run("named string and text marshaler succeed", func(t *testing.T) {
+		type named string
+		assert.NoError(t, (&MatchString{re: regexp.MustCompile("^abc")}).Test(newRC(&fakeTester{}), named("abcdef")))
+		ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		assert.NoError(t, (&MatchString{re: regexp.MustCompile("^2024-")}).Test(newRC(&fakeTester{}), ts))
+	})
 }
 
 func TestElementsMatchRule(t *testing.T) {
@@ -187,6 +208,532 @@ func TestElementsMatchRule(t *testing.T) {
 		c := &ElementsMatch{expected: []any{1, 2, 3}}
 		assert.Error(t, c.Test(newRC(&fakeTester{}), []int{1, 2, 4}))
 	})
+
+	t.Run("loose element does not steal the only match succeeds", func(t *testing.T) {
+		c := &ElementsMatch{expected: []any{&Any{}, "a"}}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), []string{"a", "b"}))
+	})
+
+	t.Run("missing element reports closest candidate", func(t *testing.T) {
+		c := &ElementsMatch{expected: []any{1, 2, 3}}
+		err := c.Test(newRC(&fakeTester{}), []int{3, 1, 4})
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, 2, merr.Expected)
+			assert.Equal(t, 4, merr.Actual)
+			assert.Contains(t, merr.Message, "closest candidate is [2]")
+			var nested *MultiError
+			if assert.ErrorAs(t, merr.Err, &nested) {
+				assert.Equal(t, Path{IndexSegment(2)}, nested.Mismatches[0].Path)
+			}
+		}
+	})
+}
+
+func TestContainsRule(t *testing.T) {
+	t.Run("actual not array returns error", func(t *testing.T) {
+		c := &ArrayContains{expected: []any{1}}
+		assert.Error(t, c.Test(newRC(&fakeTester{}), 5))
+	})
+
+	t.Run("subset in any order succeeds", func(t *testing.T) {
+		c := &ArrayContains{expected: []any{3, 1}}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), []int{1, 2, 3, 4}))
+	})
+
+	t.Run("empty expected succeeds", func(t *testing.T) {
+		c := &ArrayContains{}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), []int{}))
+	})
+
+	t.Run("missing element returns error", func(t *testing.T) {
+		c := &ArrayContains{expected: []any{1, 5}}
+		assert.Error(t, c.Test(newRC(&fakeTester{}), []int{1, 2, 3}))
+	})
+
+	t.Run("duplicates require distinct elements", func(t *testing.T) {
+		c := &ArrayContains{expected: []any{1, 1}}
+		assert.Error(t, c.Test(newRC(&fakeTester{}), []int{1, 2}))
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), []int{1, 2, 1}))
+	})
+
+	t.Run("collect reports every missing element", func(t *testing.T) {
+		c := &ArrayContains{expected: []any{1, 5, 6}}
+		rc := &RuleContext{runner: &fakeTester{}, inner: &cmpCtx{collect: true}}
+		var multi *MultiError
+		if assert.ErrorAs(t, c.Test(rc, []int{1, 2}), &multi) {
+			assert.Len(t, multi.Mismatches, 2)
+		}
+	})
+}
+
+func TestContainsInOrderRule(t *testing.T) {
+	t.Run("actual not array returns error", func(t *testing.T) {
+		c := &ArrayContainsInOrder{expected: []any{1}}
+		assert.Error(t, c.Test(newRC(&fakeTester{}), "x"))
+	})
+
+	t.Run("interleaved subsequence succeeds", func(t *testing.T) {
+		c := &ArrayContainsInOrder{expected: []any{"created", "paid", "shipped"}}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), []string{"created", "viewed", "paid", "viewed", "shipped"}))
+	})
+
+	t.Run("wrong order returns error", func(t *testing.T) {
+		c := &ArrayContainsInOrder{expected: []any{"paid", "created"}}
+		assert.Error(t, c.Test(newRC(&fakeTester{}), []string{"created", "paid"}))
+	})
+
+	t.Run("repeated element requires later occurrence", func(t *testing.T) {
+		c := &ArrayContainsInOrder{expected: []any{1, 1}}
+		assert.Error(t, c.Test(newRC(&fakeTester{}), []int{1, 2}))
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), []int{1, 2, 1}))
+	})
+}
+
+func TestEachRule(t *testing.T) {
+	t.Run("actual not array returns error", func(t *testing.T) {
+		assert.Error(t, (&EachElement{expected: 1}).Test(newRC(&fakeTester{}), 1))
+	})
+
+	t.Run("every element matches succeeds", func(t *testing.T) {
+		assert.NoError(t, (&EachElement{expected: 1}).Test(newRC(&fakeTester{}), []int{1, 1}))
+	})
+
+	t.Run("empty array succeeds", func(t *testing.T) {
+		assert.NoError(t, (&EachElement{expected: 1}).Test(newRC(&fakeTester{}), []int{}))
+	})
+
+	t.Run("failing element reports index", func(t *testing.T) {
+		err := (&EachElement{expected: 1}).Test(newRC(&fakeTester{}), []int{1, 2})
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{IndexSegment(1)}, merr.Path)
+		}
+	})
+}
+
+func TestSomeRule(t *testing.T) {
+	t.Run("one element matches succeeds", func(t *testing.T) {
+		assert.NoError(t, (&SomeElement{expected: 2}).Test(newRC(&fakeTester{}), []int{1, 2}))
+	})
+
+	t.Run("no element matches returns error", func(t *testing.T) {
+		assert.Error(t, (&SomeElement{expected: 3}).Test(newRC(&fakeTester{}), []int{1, 2}))
+	})
+
+	t.Run("empty array returns error", func(t *testing.T) {
+		assert.Error(t, (&SomeElement{expected: 3}).Test(newRC(&fakeTester{}), []int{}))
+	})
+}
+
+func TestCountRule(t *testing.T) {
+	t.Run("actual not array returns error", func(t *testing.T) {
+		assert.Error(t, (&CountElements{match: 1, eq: toPtr(1)}).Test(newRC(&fakeTester{}), "x"))
+	})
+
+	t.Run("count within bounds succeeds", func(t *testing.T) {
+		c := &CountElements{match: 1, gte: toPtr(2), lt: toPtr(4)}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), []int{1, 2, 1, 1}))
+	})
+
+	t.Run("count below bound returns error", func(t *testing.T) {
+		c := &CountElements{match: 1, gte: toPtr(2)}
+		err := c.Test(newRC(&fakeTester{}), []int{1, 2})
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, "$count gte failed: 1 elements matched, expected >= 2", merr.Message)
+			assert.Equal(t, 1, merr.Actual)
+		}
+	})
+
+	t.Run("expected rule compares count", func(t *testing.T) {
+		c := &CountElements{match: 1, expected: &numericCompare{op: "gt", ref: 1}}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), []int{1, 1}))
+		assert.Error(t, c.Test(newRC(&fakeTester{}), []int{1, 2}))
+	})
+}
+
+func TestHasTypeRule(t *testing.T) {
+	type named string
+	type item struct {
+		ID int `json:"id"`
+	}
+	now := time.Now()
+	tests := []struct {
+		name   string
+		types  []string
+		actual any
+		ok     bool
+	}{
+		{"string succeeds", []string{"string"}, "a", true},
+		{"named string succeeds", []string{"string"}, named("a"), true},
+		{"text marshaler is string", []string{"string"}, now, true},
+		{"integer is number", []string{"number"}, 3, true},
+		{"float is number", []string{"number"}, 1.5, true},
+		{"integral float is integer", []string{"integer"}, 3.0, true},
+		{"fractional float is not integer", []string{"integer"}, 1.5, false},
+		{"boolean succeeds", []string{"boolean"}, true, true},
+		{"document is object", []string{"object"}, jwalk.Document{}, true},
+		{"map is object", []string{"object"}, map[string]any{}, true},
+		{"struct is object", []string{"object"}, item{}, true},
+		{"jwalk array is array", []string{"array"}, jwalk.Array{}, true},
+		{"slice is array", []string{"array"}, []int{1}, true},
+		{"nil is null", []string{"null"}, nil, true},
+		{"nil pointer is null", []string{"null"}, (*item)(nil), true},
+		{"nil slice is null", []string{"null"}, []string(nil), true},
+		{"nil map is null", []string{"null"}, map[string]any(nil), true},
+		{"nil slice is not array", []string{"array"}, []string(nil), false},
+		{"nil map is not object", []string{"object"}, map[string]any(nil), false},
+		{"string is not number", []string{"number"}, "1", false},
+		{"one of several succeeds", []string{"string", "null"}, nil, true},
+		{"none of several returns error", []string{"string", "null"}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := (&HasType{types: tt.types}).Test(newRC(&fakeTester{}), tt.actual)
+			if tt.ok {
+				assert.NoError(t, err)
+				return
+			}
+			var merr *MismatchError
+			if assert.ErrorAs(t, err, &merr) {
+				assert.Equal(t, TypeMismatch, merr.Kind)
+				assert.Equal(t, "$type", merr.Rule)
+			}
+		})
+	}
+
+	t.Run("message names the actual type", func(t *testing.T) {
+		err := (&HasType{types: []string{"array"}}).Test(newRC(&fakeTester{}), "x")
+		assert.EqualError(t, err, "expected type array, got string")
+	})
+}
+
+func TestMatchFormatRule(t *testing.T) {
+	t.Run("valid string succeeds", func(t *testing.T) {
+		assert.NoError(t, (&MatchFormat{name: "ipv4"}).Test(newRC(&fakeTester{}), "10.0.0.1"))
+	})
+
+	t.Run("text marshaler succeeds", func(t *testing.T) {
+		assert.NoError(t, (&MatchFormat{name: "date-time"}).Test(newRC(&fakeTester{}), time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("invalid string returns error", func(t *testing.T) {
+		err := (&MatchFormat{name: "ipv4"}).Test(newRC(&fakeTester{}), "::1")
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, RuleFailed, merr.Kind)
+			assert.Equal(t, "$format", merr.Rule)
+			assert.Equal(t, "ipv4", merr.Expected)
+			assert.Error(t, merr.Err)
+		}
+	})
+
+	t.Run("non-string returns error", func(t *testing.T) {
+		err := (&MatchFormat{name: "uuid"}).Test(newRC(&fakeTester{}), 1)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, TypeMismatch, merr.Kind)
+		}
+	})
+
+	t.Run("unknown format returns error", func(t *testing.T) {
+		err := (&MatchFormat{name: "nope"}).Test(newRC(&fakeTester{}), "x")
+		assert.ErrorIs(t, err, ErrUnknownFormat)
+	})
+}
+
+func TestTimeCompareRule(t *testing.T) {
+	ref := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	clockRC := func() *RuleContext {
+		rc := newRC(&fakeTester{})
+		rc.inner.now = func() time.Time { return ref }
+		return rc
+	}
+	tests := []struct {
+		name   string
+		rule   *timeCompare
+		actual any
+		ok     bool
+	}{
+		{"after succeeds", &timeCompare{op: "after", ref: timeRef{t: ref}}, "2024-05-01T12:00:01Z", true},
+		{"after equal instant returns error", &timeCompare{op: "after", ref: timeRef{t: ref}}, "2024-05-01T14:00:00+02:00", false},
+		{"before succeeds", &timeCompare{op: "before", ref: timeRef{t: ref}}, ref.Add(-time.Second), true},
+		{"before later returns error", &timeCompare{op: "before", ref: timeRef{t: ref}}, ref.Add(time.Second), false},
+		{"timeEq across offsets succeeds", &timeCompare{op: "timeEq", ref: timeRef{t: ref}}, "2024-05-01T14:00:00+02:00", true},
+		{"timeEq different instant returns error", &timeCompare{op: "timeEq", ref: timeRef{t: ref}}, "2024-05-01T12:00:00+02:00", false},
+		{"within now succeeds", &timeCompare{op: "within", ref: timeRef{now: true}, delta: 5 * time.Second}, "2024-05-01T11:59:56Z", true},
+		{"within now too far returns error", &timeCompare{op: "within", ref: timeRef{now: true}, delta: 5 * time.Second}, "2024-05-01T12:00:06Z", false},
+		{"time pointer succeeds", &timeCompare{op: "timeEq", ref: timeRef{t: ref}}, &ref, true},
+		{"invalid string returns error", &timeCompare{op: "after", ref: timeRef{t: ref}}, "yesterday", false},
+		{"non-time returns error", &timeCompare{op: "after", ref: timeRef{t: ref}}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Test(clockRC(), tt.actual)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	t.Run("within message shows the difference", func(t *testing.T) {
+		err := (&timeCompare{op: "within", ref: timeRef{t: ref}, delta: time.Second}).Test(clockRC(), "2024-05-01T12:00:03Z")
+		assert.EqualError(t, err, "$within failed: 2024-05-01T12:00:03Z is 3s from 2024-05-01T12:00:00Z, expected within 1s")
+	})
+}
+
+func TestApproxEqualRule(t *testing.T) {
+	t.Run("within abs succeeds", func(t *testing.T) {
+		assert.NoError(t, (&ApproxEqual{value: 3.14, tol: tolerance{abs: 0.001}}).Test(newRC(&fakeTester{}), 3.1405))
+	})
+
+	t.Run("within rel succeeds", func(t *testing.T) {
+		assert.NoError(t, (&ApproxEqual{value: 100, tol: tolerance{rel: 0.01}}).Test(newRC(&fakeTester{}), 101))
+	})
+
+	t.Run("outside tolerance reports delta", func(t *testing.T) {
+		err := (&ApproxEqual{value: 3.14, tol: tolerance{abs: 0.001}}).Test(newRC(&fakeTester{}), 3.5)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, RuleFailed, merr.Kind)
+			assert.Contains(t, merr.Message, "delta 0.36")
+			assert.Contains(t, merr.Message, "exceeds abs 0.001")
+		}
+	})
+
+	t.Run("pointer and named number succeed", func(t *testing.T) {
+		type ratio float64
+		f := 3.1405
+		assert.NoError(t, (&ApproxEqual{value: 3.14, tol: tolerance{abs: 0.001}}).Test(newRC(&fakeTester{}), &f))
+		assert.NoError(t, (&ApproxEqual{value: 3.14, tol: tolerance{abs: 0.001}}).Test(newRC(&fakeTester{}), ratio(3.1405)))
+	})
+
+	t.Run("non-numeric returns error", func(t *testing.T) {
+		err := (&ApproxEqual{value: 1, tol: tolerance{abs: 1}}).Test(newRC(&fakeTester{}), "1")
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, TypeMismatch, merr.Kind)
+		}
+	})
+}
+
+func TestStringCompareRule(t *testing.T) {
+	type named string
+	tests := []struct {
+		name   string
+		rule   *stringCompare
+		actual any
+		ok     bool
+	}{
+		{"startsWith succeeds", &stringCompare{op: "startsWith", value: "user "}, "user not found", true},
+		{"startsWith returns error", &stringCompare{op: "startsWith", value: "User"}, "user not found", false},
+		{"startsWith ignoring case succeeds", &stringCompare{op: "startsWith", value: "User", norm: stringNorm{ignoreCase: true}}, "user not found", true},
+		{"endsWith succeeds", &stringCompare{op: "endsWith", value: "found"}, named("user not found"), true},
+		{"endsWith trimming space succeeds", &stringCompare{op: "endsWith", value: "found", norm: stringNorm{trimSpace: true}}, "user not found \n", true},
+		{"containsString succeeds", &stringCompare{op: "containsString", value: "not found"}, "user not found", true},
+		{"containsString collapsing whitespace succeeds", &stringCompare{op: "containsString", value: "not found", norm: stringNorm{collapseWhitespace: true}}, "user not\t\n found", true},
+		{"containsString returns error", &stringCompare{op: "containsString", value: "not found"}, "user not\tfound", false},
+		{"ieq succeeds", &stringCompare{op: "ieq", value: "Alice@Example.com", norm: stringNorm{ignoreCase: true}}, "alice@example.COM", true},
+		{"ieq returns error", &stringCompare{op: "ieq", value: "alice", norm: stringNorm{ignoreCase: true}}, "alicia", false},
+		{"ieq folding final sigma succeeds", &stringCompare{op: "ieq", value: "ΟΔΟΣ", norm: stringNorm{ignoreCase: true}}, "οδος", true},
+		{"endsWith ignoring case folds final sigma succeeds", &stringCompare{op: "endsWith", value: "ΟΣ", norm: stringNorm{ignoreCase: true}}, "οδος", true},
+		{"containsString ignoring case folds kelvin sign succeeds", &stringCompare{op: "containsString", value: "k", norm: stringNorm{ignoreCase: true}}, "5 \u212a", true},
+		{"non-string returns error", &stringCompare{op: "startsWith", value: "1"}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Test(newRC(&fakeTester{}), tt.actual)
+			if tt.ok {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	t.Run("messages report divergence offset", func(t *testing.T) {
+		rc := newRC(&fakeTester{})
+		assert.EqualError(t, (&stringCompare{op: "startsWith", value: "abd"}).Test(rc, "abcdef"), `$startsWith failed: "abcdef" does not start with "abd" (diverges at offset 2)`)
+		assert.EqualError(t, (&stringCompare{op: "endsWith", value: "xef"}).Test(rc, "abcdef"), `$endsWith failed: "abcdef" does not end with "xef" (diverges at offset 3)`)
+		assert.EqualError(t, (&stringCompare{op: "containsString", value: "cdx"}).Test(rc, "abcdef"), `$containsString failed: "abcdef" does not contain "cdx" (closest match at offset 2 diverges after 2 characters)`)
+		assert.EqualError(t, (&stringCompare{op: "ieq", value: "héllo", norm: stringNorm{ignoreCase: true}}).Test(rc, "HÉLLA"), `$ieq failed: "HÉLLA" differs from "héllo" at offset 4`)
+	})
+
+	t.Run("offsets refer to the actual string as given", func(t *testing.T) {
+		rc := newRC(&fakeTester{})
+		norm := stringNorm{trimSpace: true, collapseWhitespace: true}
+		assert.EqualError(t, (&stringCompare{op: "startsWith", value: "a b d", norm: norm}).Test(rc, "  a   b c"), `$startsWith failed: "  a   b c" does not start with "a b d" (diverges at offset 8)`)
+		assert.EqualError(t, (&stringCompare{op: "endsWith", value: "x c", norm: norm}).Test(rc, "a   b c  "), `$endsWith failed: "a   b c  " does not end with "x c" (diverges at offset 4)`)
+		assert.EqualError(t, (&stringCompare{op: "containsString", value: "b cx", norm: norm}).Test(rc, " a  b   c"), `$containsString failed: " a  b   c" does not contain "b cx" (closest match at offset 4 diverges after 3 characters)`)
+	})
+}
+
+func Test_stringNorm(t *testing.T) {
+	t.Run("collapse whitespace succeeds", func(t *testing.T) {
+		got, at := stringNorm{collapseWhitespace: true}.apply("\t a \n\n b  ")
+		assert.Equal(t, " a b ", got)
+		assert.Equal(t, []int{0, 2, 3, 7, 8, 10}, at)
+	})
+
+	t.Run("all normalizations keep case succeeds", func(t *testing.T) {
+		got, at := stringNorm{ignoreCase: true, trimSpace: true, collapseWhitespace: true}.apply("  A \t B \n")
+		assert.Equal(t, "A B", got)
+		assert.Equal(t, []int{2, 3, 6, 7}, at)
+	})
+}
+
+func TestParseJSONRule(t *testing.T) {
+	t.Run("registry unmarshalers decode documents succeeds", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "id", Value: 1.0}, {Key: "tags", Value: jwalk.Array{"a"}}}
+		c := &ParseJSON{expected: exp, unmarshalers: jwalk.Unmarshalers(jwalk.DefaultRegistry())}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), `{"id":1,"tags":["a"]}`))
+	})
+
+	t.Run("without unmarshalers decodes plain value succeeds", func(t *testing.T) {
+		exp := map[string]any{"id": 1.0}
+		assert.NoError(t, (&ParseJSON{expected: exp}).Test(newRC(&fakeTester{}), `{"id":1}`))
+	})
+
+	t.Run("bytes succeed", func(t *testing.T) {
+		assert.NoError(t, (&ParseJSON{expected: []any{"a"}}).Test(newRC(&fakeTester{}), []byte(`["a"]`)))
+	})
+
+	t.Run("without unmarshalers dollar keys stay data succeeds", func(t *testing.T) {
+		exp := map[string]any{"$schema": "x", "id": map[string]any{"$gt": 0.0}}
+		assert.NoError(t, (&ParseJSON{expected: exp}).Test(newRC(&fakeTester{}), `{"$schema":"x","id":{"$gt":0}}`))
+	})
+
+	t.Run("invalid json returns error", func(t *testing.T) {
+		err := (&ParseJSON{expected: 1.0}).Test(newRC(&fakeTester{}), `{"id":`)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, RuleFailed, merr.Kind)
+			assert.Equal(t, "$parseJSON", merr.Rule)
+			assert.Error(t, merr.Err)
+		}
+	})
+
+	t.Run("non-string returns error", func(t *testing.T) {
+		var merr *MismatchError
+		if assert.ErrorAs(t, (&ParseJSON{expected: 1.0}).Test(newRC(&fakeTester{}), 1), &merr) {
+			assert.Equal(t, TypeMismatch, merr.Kind)
+		}
+	})
+}
+
+func TestDecodeBase64Rule(t *testing.T) {
+	t.Run("decoded string succeeds", func(t *testing.T) {
+		assert.NoError(t, (&DecodeBase64{expected: "hello"}).Test(newRC(&fakeTester{}), "aGVsbG8="))
+	})
+
+	t.Run("unpadded input succeeds", func(t *testing.T) {
+		assert.NoError(t, (&DecodeBase64{expected: "hello"}).Test(newRC(&fakeTester{}), "aGVsbG8"))
+	})
+
+	t.Run("url encoding succeeds", func(t *testing.T) {
+		assert.NoError(t, (&DecodeBase64{expected: "\xfb\xff", url: true}).Test(newRC(&fakeTester{}), "-_8"))
+	})
+
+	t.Run("bytes succeed", func(t *testing.T) {
+		assert.NoError(t, (&DecodeBase64{expected: []byte("hi"), as: "bytes"}).Test(newRC(&fakeTester{}), "aGk="))
+	})
+
+	t.Run("json succeeds", func(t *testing.T) {
+		exp := map[string]any{"id": 1.0}
+		assert.NoError(t, (&DecodeBase64{expected: exp, as: "json"}).Test(newRC(&fakeTester{}), "eyJpZCI6MX0="))
+	})
+
+	t.Run("decoded mismatch returns error", func(t *testing.T) {
+		assert.Error(t, (&DecodeBase64{expected: "bye"}).Test(newRC(&fakeTester{}), "aGVsbG8="))
+	})
+
+	t.Run("invalid base64 returns error", func(t *testing.T) {
+		var merr *MismatchError
+		if assert.ErrorAs(t, (&DecodeBase64{expected: "x"}).Test(newRC(&fakeTester{}), "%%%"), &merr) {
+			assert.Equal(t, RuleFailed, merr.Kind)
+			assert.Equal(t, "$base64", merr.Rule)
+		}
+	})
+
+	t.Run("invalid padding returns error", func(t *testing.T) {
+		for _, s := range []string{"===", "aGVsbG8==", "aGk==", "aG=k"} {
+			assert.Error(t, (&DecodeBase64{expected: ""}).Test(newRC(&fakeTester{}), s), s)
+		}
+	})
+
+	t.Run("invalid json returns error", func(t *testing.T) {
+		assert.Error(t, (&DecodeBase64{expected: 1.0, as: "json"}).Test(newRC(&fakeTester{}), "aGVsbG8="))
+	})
+}
+
+func TestDecodeJWTRule(t *testing.T) {
+	// {"alg":"HS256","typ":"JWT"}.{"sub":"42","admin":true}.sig
+	const token = "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJzdWIiOiI0MiIsImFkbWluIjp0cnVlfQ.c2ln"
+
+	t.Run("decoded token succeeds", func(t *testing.T) {
+		exp := jwalk.Document{
+			{Key: "header", Value: map[string]any{"alg": "HS256", "typ": "JWT"}},
+			{Key: "claims", Value: map[string]any{"sub": "42", "admin": true}},
+			{Key: "signature", Value: "c2ln"},
+		}
+		assert.NoError(t, (&DecodeJWT{expected: exp}).Test(newRC(&fakeTester{}), token))
+	})
+
+	t.Run("wrong segment count returns error", func(t *testing.T) {
+		assert.Error(t, (&DecodeJWT{expected: 1}).Test(newRC(&fakeTester{}), "a.b"))
+	})
+
+	t.Run("invalid claims returns error", func(t *testing.T) {
+		var merr *MismatchError
+		if assert.ErrorAs(t, (&DecodeJWT{expected: 1}).Test(newRC(&fakeTester{}), "eyJhbGciOiJub25lIn0.bm9wZQ.c2ln"), &merr) {
+			assert.Equal(t, "$jwt", merr.Rule)
+			assert.Contains(t, merr.Message, "invalid claims JSON")
+		}
+	})
+
+	t.Run("segment padding must be valid", func(t *testing.T) {
+		exp := jwalk.Document{
+			{Key: "header", Value: map[string]any{"alg": "none"}},
+			{Key: "claims", Value: map[string]any{}},
+			{Key: "signature", Value: ""},
+		}
+		assert.NoError(t, (&DecodeJWT{expected: exp}).Test(newRC(&fakeTester{}), "eyJhbGciOiJub25lIn0=.e30."))
+		var merr *MismatchError
+		if assert.ErrorAs(t, (&DecodeJWT{expected: exp}).Test(newRC(&fakeTester{}), "eyJhbGciOiJub25lIn0==.e30."), &merr) {
+			assert.Contains(t, merr.Message, "invalid header encoding")
+		}
+	})
+
+	t.Run("non-string returns error", func(t *testing.T) {
+		assert.Error(t, (&DecodeJWT{expected: 1}).Test(newRC(&fakeTester{}), 1))
+	})
+}
+
+func TestParseURLRule(t *testing.T) {
+	t.Run("parts document succeeds", func(t *testing.T) {
+		exp := jwalk.Document{
+			{Key: "scheme", Value: "https"},
+			{Key: "host", Value: "example.com:8443"},
+			{Key: "path", Value: "/cb"},
+			{Key: "query", Value: jwalk.Document{{Key: "a", Value: jwalk.Array{"1", "2"}}, {Key: "state", Value: "x y"}}},
+			{Key: "fragment", Value: "top"},
+		}
+		assert.NoError(t, (&ParseURL{expected: exp}).Test(newRC(&fakeTester{}), "https://example.com:8443/cb?state=x+y&a=1&a=2#top"))
+	})
+
+	t.Run("invalid url returns error", func(t *testing.T) {
+		var merr *MismatchError
+		if assert.ErrorAs(t, (&ParseURL{expected: 1}).Test(newRC(&fakeTester{}), "http://[::1"), &merr) {
+			assert.Equal(t, RuleFailed, merr.Kind)
+		}
+	})
+
+	t.Run("non-string returns error", func(t *testing.T) {
+		assert.Error(t, (&ParseURL{expected: 1}).Test(newRC(&fakeTester{}), 1))
+	})
 }
 
 func TestLengthRule(t *testing.T) {
@@ -200,6 +747,11 @@ func TestLengthRule(t *testing.T) {
 		assert.NoError(t, c.Test(newRC(&fakeTester{}), []int{1, 2, 3}))
 	})
 
+	t.Run("pointer to slice succeeds", func(t *testing.T) {
+		c := &Length{eq: toPtr(3)}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), &[]int{1, 2, 3}))
+	})
+
 	t.Run("eq mismatch returns error", func(t *testing.T) {
 		c := &Length{eq: toPtr(3)}
 		assert.Error(t, c.Test(newRC(&fakeTester{}), []int{1, 2}))
@@ -254,6 +806,16 @@ func TestLengthRule(t *testing.T) {
 		c := &Length{gt: toPtr(2), lt: toPtr(5)}
 		assert.Error(t, c.Test(newRC(&fakeTester{}), []int{1, 2, 3, 4, 5}))
 	})
+
+	t.Run("expected rule succeeds", func(t *testing.T) {
+		c := &Length{expected: &numericCompare{op: "gt", ref: 1, incl: true}}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), []int{1}))
+	})
+
+	t.Run("expected rule returns error", func(t *testing.T) {
+		c := &Length{expected: &numericCompare{op: "gt", ref: 1, incl: true}}
+		assert.Error(t, c.Test(newRC(&fakeTester{}), []int{}))
+	})
 }
 
 func TestEmptyRule(t *testing.T) {
@@ -293,6 +855,16 @@ func TestNotEqualRule(t *testing.T) {
 		c := &NotEqual{expected: jwalk.Document{{Key: "a", Value: 1}}}
 		assert.Error(t, c.Test(newRC(&fakeTester{}), jwalk.Document{{Key: "a", Value: 1}}))
 	})
+
+	t.Run("values equal after normalization return error", func(t *testing.T) {
+		type status string
+		s := "x"
+		ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		assert.Error(t, (&NotEqual{expected: "ok"}).Test(newRC(&fakeTester{}), status("ok")))
+		assert.Error(t, (&NotEqual{expected: "x"}).Test(newRC(&fakeTester{}), &s))
+		assert.Error(t, (&NotEqual{expected: "2024-01-01T00:00:00Z"}).Test(newRC(&fakeTester{}), ts))
+		assert.NoError(t, (&NotEqual{expected: "2024-01-02T00:00:00Z"}).Test(newRC(&fakeTester{}), ts))
+	})
 }
 
 func TestLessThanRule(t *testing.T) {
@@ -348,6 +920,14 @@ func TestGreaterThanRule(t *testing.T) {
 		assert.Error(t, (&numericCompare{op: "gt", ref: 10}).Test(newRC(&fakeTester{}), 10))
 	})
 
+	t.Run("pointer and named number pass", func(t *testing.T) {
+		type count int
+		n := 11
+		assert.NoError(t, (&numericCompare{op: "gt", ref: 10}).Test(newRC(&fakeTester{}), &n))
+		assert.NoError(t, (&numericCompare{op: "gt", ref: 10}).Test(newRC(&fakeTester{}), count(11)))
+		assert.Error(t, (&numericCompare{op: "gt", ref: 10}).Test(newRC(&fakeTester{}), (*int)(nil)))
+	})
+
 	t.Run("op not recognized returns error", func(t *testing.T) {
 		assert.Error(t, (&numericCompare{op: "unknown", ref: 10}).Test(newRC(&fakeTester{}), 5))
 	})
@@ -449,4 +1029,163 @@ func TestNotRule(t *testing.T) {
 	})
 }
 
+func TestExistsRule(t *testing.T) {
+	t.Run("exists true on present value succeeds", func(t *testing.T) {
+		c := &Exists{want: true}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), nil))
+	})
+
+	t.Run("exists true on missing key returns error", func(t *testing.T) {
+		c := &Exists{want: true}
+		assert.ErrorIs(t, c.TestAbsent(newRC(&fakeTester{})), ErrKeyMissing)
+	})
+
+	t.Run("exists false on present value returns error", func(t *testing.T) {
+		c := &Exists{want: false}
+		assert.ErrorIs(t, c.Test(newRC(&fakeTester{}), 5), ErrKeyPresent)
+	})
+
+	t.Run("exists false on missing key succeeds", func(t *testing.T) {
+		c := &Exists{want: false}
+		assert.NoError(t, c.TestAbsent(newRC(&fakeTester{})))
+	})
+}
+
+func TestOptionalRule(t *testing.T) {
+	t.Run("missing key succeeds", func(t *testing.T) {
+		c := &Optional{expected: 5}
+		assert.NoError(t, c.TestAbsent(newRC(&fakeTester{})))
+	})
+
+	t.Run("present matching value succeeds", func(t *testing.T) {
+		c := &Optional{expected: 5}
+		assert.NoError(t, c.Test(newRC(&fakeTester{}), 5))
+	})
+
+	t.Run("present mismatching value returns error", func(t *testing.T) {
+		c := &Optional{expected: 5}
+		assert.Error(t, c.Test(newRC(&fakeTester{}), 6))
+	})
+
+	t.Run("present null value returns error", func(t *testing.T) {
+		c := &Optional{expected: &MatchString{re: regexp.MustCompile("^a")}}
+		assert.Error(t, c.Test(newRC(&fakeTester{}), nil))
+	})
+}
+
+func TestLogicalRulesAbsent(t *testing.T) {
+	t.Run("and all absent rules pass succeeds", func(t *testing.T) {
+		r := &And{rules: []any{&Exists{want: false}, &Optional{expected: 1}}}
+		assert.NoError(t, r.TestAbsent(newRC(&fakeTester{})))
+	})
+
+	t.Run("and with plain operand returns error", func(t *testing.T) {
+		r := &And{rules: []any{&Exists{want: false}, 1}}
+		assert.ErrorIs(t, r.TestAbsent(newRC(&fakeTester{})), ErrKeyMissing)
+	})
+
+	t.Run("or with absent alternative succeeds", func(t *testing.T) {
+		r := &Or{rules: []any{1, &Exists{want: false}}}
+		assert.NoError(t, r.TestAbsent(newRC(&fakeTester{})))
+	})
+
+	t.Run("or without absent alternative returns error", func(t *testing.T) {
+		r := &Or{rules: []any{1, 2}}
+		assert.Error(t, r.TestAbsent(newRC(&fakeTester{})))
+	})
+
+	t.Run("not exists true succeeds", func(t *testing.T) {
+		r := &Not{rule: &Exists{want: true}}
+		assert.NoError(t, r.TestAbsent(newRC(&fakeTester{})))
+	})
+
+	t.Run("not exists false returns error", func(t *testing.T) {
+		r := &Not{rule: &Exists{want: false}}
+		assert.Error(t, r.TestAbsent(newRC(&fakeTester{})))
+	})
+
+	t.Run("not plain operand returns error", func(t *testing.T) {
+		r := &Not{rule: 5}
+		assert.ErrorIs(t, r.TestAbsent(newRC(&fakeTester{})), ErrKeyMissing)
+	})
+
+	t.Run("nor matching absent alternative returns error", func(t *testing.T) {
+		r := &Nor{rules: []any{&Exists{want: false}}}
+		assert.Error(t, r.TestAbsent(newRC(&fakeTester{})))
+	})
+
+	t.Run("nor no matching absent alternative succeeds", func(t *testing.T) {
+		r := &Nor{rules: []any{&Exists{want: true}}}
+		assert.NoError(t, r.TestAbsent(newRC(&fakeTester{})))
+	})
+}
+
+func TestBuiltinRuleMismatchDetails(t *testing.T) {
+	check := func(t *testing.T, err error, kind MismatchKind, rule string) *MismatchError {
+		t.Helper()
+		var merr *MismatchError
+		if !assert.ErrorAs(t, err, &merr) {
+			t.FailNow()
+		}
+		assert.Equal(t, kind, merr.Kind)
+		assert.Equal(t, rule, merr.Rule)
+		return merr
+	}
+	rc := newRC(&fakeTester{})
+
+	t.Run("nil reports RuleFailed and unwraps sentinel", func(t *testing.T) {
+		merr := check(t, (&Nil{expected: true, wanted: true}).Test(rc, 5), RuleFailed, "$nil")
+		assert.ErrorIs(t, merr, ErrNil)
+		assert.Equal(t, 5, merr.Actual)
+	})
+
+	t.Run("required reports RuleFailed", func(t *testing.T) {
+		merr := check(t, (&Required{want: true}).Test(rc, 0), RuleFailed, "$required")
+		assert.ErrorIs(t, merr, ErrRequired)
+	})
+
+	t.Run("regex reports pattern and actual", func(t *testing.T) {
+		merr := check(t, (&MatchString{re: regexp.MustCompile("^a")}).Test(rc, "b"), RuleFailed, "$regex")
+		assert.Equal(t, "^a", merr.Expected)
+		assert.Equal(t, "b", merr.Actual)
+		check(t, (&MatchString{re: regexp.MustCompile("^a")}).Test(rc, 1), TypeMismatch, "$regex")
+	})
+
+	t.Run("elementsMatch reports length and rule failures", func(t *testing.T) {
+		check(t, (&ElementsMatch{expected: []any{1}}).Test(rc, []int{1, 2}), LengthMismatch, "$elementsMatch")
+		check(t, (&ElementsMatch{expected: []any{1}}).Test(rc, []int{2}), RuleFailed, "$elementsMatch")
+	})
+
+	t.Run("length reports LengthMismatch", func(t *testing.T) {
+		merr := check(t, (&Length{eq: toPtr(2)}).Test(rc, []int{1}), LengthMismatch, "$length")
+		assert.Equal(t, 2, merr.Expected)
+		assert.Equal(t, 1, merr.Actual)
+	})
+
+	t.Run("empty ne and in report RuleFailed", func(t *testing.T) {
+		check(t, (&Empty{want: true}).Test(rc, []int{1}), RuleFailed, "$empty")
+		check(t, (&NotEqual{expected: 1}).Test(rc, 1), RuleFailed, "$ne")
+		check(t, (&InSet{elems: []any{1}}).Test(rc, 2), RuleFailed, "$in")
+	})
+
+	t.Run("numeric comparators report directive name", func(t *testing.T) {
+		check(t, (&numericCompare{op: "lt", ref: 1}).Test(rc, 2), RuleFailed, "$lt")
+		check(t, (&numericCompare{op: "lt", ref: 1, incl: true}).Test(rc, 2), RuleFailed, "$lte")
+		check(t, (&numericCompare{op: "gt", ref: 1}).Test(rc, 0), RuleFailed, "$gt")
+		check(t, (&numericCompare{op: "gt", ref: 1, incl: true}).Test(rc, "x"), TypeMismatch, "$gte")
+	})
+
+	t.Run("logical operators report RuleFailed", func(t *testing.T) {
+		check(t, (&And{rules: []any{1}}).Test(rc, 2), RuleFailed, "$and")
+		check(t, (&Or{rules: []any{1}}).Test(rc, 2), RuleFailed, "$or")
+		check(t, (&Nor{rules: []any{1}}).Test(rc, 1), RuleFailed, "$nor")
+		check(t, (&Not{rule: 1}).Test(rc, 1), RuleFailed, "$not")
+	})
+
+	t.Run("exists reports key kinds", func(t *testing.T) {
+		check(t, (&Exists{want: false}).Test(rc, 1), UnexpectedKey, "$exists")
+		check(t, (&Exists{want: true}).TestAbsent(rc), KeyNotFound, "$exists")
+	})
+}
+
 func toPtr(i int) *int { return &i }
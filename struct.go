@@ -0,0 +1,337 @@
+package testequals
+
+import (
+	"encoding"
+	"encoding/base64"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/calumari/jwalk"
+	"github.com/go-json-experiment/json"
+	"github.com/go-json-experiment/json/jsontext"
+)
+
+// isZeroer is implemented by types that define their own zero value for
+// omitzero, such as time.Time.
+type isZeroer interface{ IsZero() bool }
+
+var (
+	textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+	jsonMarshalerType = reflect.TypeFor[json.Marshaler]()
+	isZeroerType      = reflect.TypeFor[isZeroer]()
+)
+
+// scalarTypes maps scalar kinds to their predeclared Go types so that values of
+// named types (e.g. `type Status string`) compare like their underlying type.
+var scalarTypes = map[reflect.Kind]reflect.Type{
+	reflect.Bool:    reflect.TypeFor[bool](),
+	reflect.Int:     reflect.TypeFor[int](),
+	reflect.Int8:    reflect.TypeFor[int8](),
+	reflect.Int16:   reflect.TypeFor[int16](),
+	reflect.Int32:   reflect.TypeFor[int32](),
+	reflect.Int64:   reflect.TypeFor[int64](),
+	reflect.Uint:    reflect.TypeFor[uint](),
+	reflect.Uint8:   reflect.TypeFor[uint8](),
+	reflect.Uint16:  reflect.TypeFor[uint16](),
+	reflect.Uint32:  reflect.TypeFor[uint32](),
+	reflect.Uint64:  reflect.TypeFor[uint64](),
+	reflect.Float32: reflect.TypeFor[float32](),
+	reflect.Float64: reflect.TypeFor[float64](),
+	reflect.String:  reflect.TypeFor[string](),
+}
+
+// isTextMarshaler reports whether values of t encode as JSON text of their own
+// (e.g. time.Time), in which case structs are not walked field by field.
+func isTextMarshaler(t reflect.Type) bool {
+	return t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)
+}
+
+// marshaledValue decodes the JSON encoding of v when its type implements
+// json.Marshaler, as encoding/json would serialize it, into the map[string]any,
+// []any and primitive values encoding/json decodes to. Types that are also
+// text marshalers, such as time.Time, keep being compared through their text
+// form. It reports false for other types and when encoding fails.
+func marshaledValue(v reflect.Value) (any, bool) {
+	if !v.IsValid() || isTextMarshaler(v.Type()) {
+		return nil, false
+	}
+	var m json.Marshaler
+	switch {
+	case v.Type().Implements(jsonMarshalerType):
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return nil, false
+		}
+		m = v.Interface().(json.Marshaler)
+	case v.CanAddr() && reflect.PointerTo(v.Type()).Implements(jsonMarshalerType):
+		m = v.Addr().Interface().(json.Marshaler)
+	default:
+		return nil, false
+	}
+	data, err := m.MarshalJSON()
+	if err != nil {
+		return nil, false
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, false
+	}
+	return decoded, true
+}
+
+// plainValue unwraps v into the representation used for comparison: pointers
+// and interfaces are dereferenced (nil becomes nil), json.Marshaler values are
+// replaced by their decoded encoding (see marshaledValue), nil slices and maps
+// become nil as encoding/json writes them as null and named scalar types are
+// converted to their underlying Go type. Types with their own text encoding are
+// left untouched.
+func plainValue(v reflect.Value) any {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil
+	}
+	if !v.CanInterface() {
+		// An unexported embedded struct named by a json tag is only reachable
+		// by reflection; encoding/json encodes its exported fields.
+		return structDocument(v)
+	}
+	if decoded, ok := marshaledValue(v); ok {
+		return decoded
+	}
+	if (v.Kind() == reflect.Slice || v.Kind() == reflect.Map) && v.IsNil() {
+		return nil
+	}
+	if st, ok := scalarTypes[v.Kind()]; ok && v.Type() != st && !isTextMarshaler(v.Type()) {
+		return v.Convert(st).Interface()
+	}
+	return v.Interface()
+}
+
+// jsonValue is plainValue for the members of actual containers, which also
+// encodes byte slices as base64 strings like encoding/json.
+func jsonValue(v reflect.Value) any {
+	p := plainValue(v)
+	if b := reflect.ValueOf(p); b.Kind() == reflect.Slice && b.Type().Elem().Kind() == reflect.Uint8 {
+		return base64.StdEncoding.EncodeToString(b.Bytes())
+	}
+	return p
+}
+
+// structField describes how a Go struct field is exposed as a JSON object
+// member, following encoding/json conventions.
+type structField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+	omitZero  bool
+	// quoted is set by the ",string" option on a string, number or boolean
+	// field, whose JSON encoding is then wrapped in a string.
+	quoted bool
+}
+
+var structFieldCache sync.Map // map[reflect.Type][]structField
+
+// structFields returns the JSON-visible fields of struct type t, honoring
+// `json` tags ("-", renames, omitempty, omitzero, string), skipping unexported
+// fields and promoting the fields of embedded structs. Name conflicts are
+// resolved like encoding/json: the shallowest field wins, then a tagged one;
+// remaining ambiguous fields are dropped.
+func structFields(t reflect.Type) []structField {
+	if f, ok := structFieldCache.Load(t); ok {
+		return f.([]structField)
+	}
+
+	type queued struct {
+		typ   reflect.Type
+		index []int
+	}
+
+	var candidates []fieldCandidate
+	visited := map[reflect.Type]bool{}
+	current := []queued{{typ: t}}
+	for depth := 0; len(current) > 0; depth++ {
+		var next []queued
+		for _, q := range current {
+			if visited[q.typ] {
+				continue
+			}
+			visited[q.typ] = true
+			for i := range q.typ.NumField() {
+				sf := q.typ.Field(i)
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name, opts, _ := strings.Cut(tag, ",")
+				index := append(slices.Clone(q.index), i)
+				if sf.Anonymous {
+					ft := sf.Type
+					if ft.Kind() == reflect.Pointer {
+						ft = ft.Elem()
+					}
+					if !sf.IsExported() && ft.Kind() != reflect.Struct {
+						continue
+					}
+					if name == "" && ft.Kind() == reflect.Struct {
+						next = append(next, queued{typ: ft, index: index})
+						continue
+					}
+				} else if !sf.IsExported() {
+					continue
+				}
+				c := fieldCandidate{depth: depth, tagged: name != ""}
+				if name == "" {
+					name = sf.Name
+				}
+				c.structField = structField{name: name, index: index}
+				for opts != "" {
+					var opt string
+					opt, opts, _ = strings.Cut(opts, ",")
+					switch opt {
+					case "omitempty":
+						c.omitEmpty = true
+					case "omitzero":
+						c.omitZero = true
+					case "string":
+						c.quoted = quotable(sf.Type)
+					}
+				}
+				candidates = append(candidates, c)
+			}
+		}
+		current = next
+	}
+
+	byName := make(map[string][]fieldCandidate, len(candidates))
+	for _, c := range candidates {
+		byName[c.name] = append(byName[c.name], c)
+	}
+	fields := make([]structField, 0, len(byName))
+	for _, group := range byName {
+		if f, ok := dominantField(group); ok {
+			fields = append(fields, f)
+		}
+	}
+	slices.SortFunc(fields, func(a, b structField) int { return slices.Compare(a.index, b.index) })
+
+	f, _ := structFieldCache.LoadOrStore(t, fields)
+	return f.([]structField)
+}
+
+// quotable reports whether the ",string" option applies to fields of type t:
+// strings, numbers and booleans, or unnamed pointers to them, without an
+// encoding of their own.
+func quotable(t reflect.Type) bool {
+	if t.Name() == "" && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if isTextMarshaler(t) || t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return false
+	}
+	_, ok := scalarTypes[t.Kind()]
+	return ok
+}
+
+type fieldCandidate struct {
+	structField
+	depth  int
+	tagged bool
+}
+
+// dominantField picks the field encoding/json would serialize among candidates
+// sharing a name: the one at the minimum depth, else the single tagged one at
+// that depth. It returns false when the choice is ambiguous.
+func dominantField(group []fieldCandidate) (structField, bool) {
+	minDepth := group[0].depth
+	for _, c := range group[1:] {
+		minDepth = min(minDepth, c.depth)
+	}
+	var shallow, tagged []fieldCandidate
+	for _, c := range group {
+		if c.depth != minDepth {
+			continue
+		}
+		shallow = append(shallow, c)
+		if c.tagged {
+			tagged = append(tagged, c)
+		}
+	}
+	switch {
+	case len(shallow) == 1:
+		return shallow[0].structField, true
+	case len(tagged) == 1:
+		return tagged[0].structField, true
+	default:
+		return structField{}, false
+	}
+}
+
+// structDocument views struct value v as a jwalk.Document keyed by JSON field
+// names, with values as encoding/json would serialize them (see jsonValue and
+// structField.quoted). Fields omitted by omitempty/omitzero, or unreachable
+// through a nil embedded pointer, are left out so they behave as missing keys.
+func structDocument(v reflect.Value) jwalk.Document {
+	fields := structFields(v.Type())
+	doc := make(jwalk.Document, 0, len(fields))
+	for _, f := range fields {
+		fv, err := v.FieldByIndexErr(f.index)
+		if err != nil {
+			continue
+		}
+		if f.omitEmpty && isEmptyJSON(fv) || f.omitZero && isZeroJSON(fv) {
+			continue
+		}
+		val := jsonValue(fv)
+		if f.quoted && val != nil {
+			if b, err := json.Marshal(val, jsontext.EscapeForHTML(true)); err == nil {
+				val = string(b)
+			}
+		}
+		doc = append(doc, jwalk.Entry{Key: f.name, Value: val})
+	}
+	return doc
+}
+
+// isZeroJSON mirrors the encoding/json definition of a zero value for
+// omitzero, which uses the type's IsZero method when it has one.
+func isZeroJSON(v reflect.Value) bool {
+	t := v.Type()
+	switch {
+	case (t.Kind() == reflect.Pointer || t.Kind() == reflect.Interface) && t.Implements(isZeroerType):
+		return v.IsNil() || v.Interface().(isZeroer).IsZero()
+	case t.Implements(isZeroerType):
+		return v.Interface().(isZeroer).IsZero()
+	case reflect.PointerTo(t).Implements(isZeroerType):
+		if !v.CanAddr() {
+			c := reflect.New(t).Elem()
+			c.Set(v)
+			v = c
+		}
+		return v.Addr().Interface().(isZeroer).IsZero()
+	default:
+		return v.IsZero()
+	}
+}
+
+// isEmptyJSON mirrors the encoding/json definition of an empty value for
+// omitempty, which unlike isEmpty never treats structs as empty.
+func isEmptyJSON(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	default:
+		return false
+	}
+}
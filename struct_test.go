@@ -0,0 +1,281 @@
+package testequals
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/calumari/jwalk"
+	"github.com/stretchr/testify/assert"
+)
+
+type status string
+
+type baseEntity struct {
+	ID      int    `json:"id"`
+	Created string `json:"createdAt,omitempty"`
+}
+
+type auditInfo struct {
+	By string
+}
+
+type structUser struct {
+	baseEntity
+	*auditInfo
+	Name     string   `json:"name"`
+	Nickname *string  `json:"nickname,omitempty"`
+	Status   status   `json:"status"`
+	Secret   string   `json:"-"`
+	Dash     string   `json:"-,"`
+	Tags     []string `json:"tags,omitempty"`
+	Untagged bool
+	private  int
+}
+
+type shadowed struct {
+	ID string `json:"id"`
+}
+
+type shadowing struct {
+	shadowed
+	ID int `json:"id"`
+}
+
+type inner struct {
+	A int `json:"a"`
+}
+
+type taggedEmbed struct {
+	inner `json:"in"`
+	B     int `json:"b"`
+}
+
+type taggedEmbedPtr struct {
+	*inner `json:"in"`
+	B      int `json:"b"`
+}
+
+type ambiguousA struct{ Name string }
+
+type ambiguousB struct{ Name string }
+
+type ambiguous struct {
+	ambiguousA
+	ambiguousB
+}
+
+// money encodes as a JSON string rather than field by field.
+type money struct {
+	cents int
+}
+
+func (m money) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf(`"%d.%02d"`, m.cents/100, m.cents%100)), nil
+}
+
+// window is zero when it has no length, whatever its start.
+type window struct {
+	Start int `json:"start"`
+	Len   int `json:"len"`
+}
+
+func (w window) IsZero() bool { return w.Len == 0 }
+
+type encoded struct {
+	Data    []byte    `json:"data"`
+	Empty   []byte    `json:"empty"`
+	Count   int       `json:"count,string"`
+	Label   string    `json:"label,string"`
+	Ratio   *float64  `json:"ratio,string"`
+	Price   money     `json:"price"`
+	Window  window    `json:"window,omitzero"`
+	Created time.Time `json:"created,omitzero"`
+}
+
+func Test_structFields(t *testing.T) {
+	names := func(typ reflect.Type) []string {
+		var out []string
+		for _, f := range structFields(typ) {
+			out = append(out, f.name)
+		}
+		return out
+	}
+
+	t.Run("tags embedded and unexported fields resolve", func(t *testing.T) {
+		got := names(reflect.TypeFor[structUser]())
+		assert.Equal(t, []string{"id", "createdAt", "By", "name", "nickname", "status", "-", "tags", "Untagged"}, got)
+	})
+
+	t.Run("shallow field shadows embedded field", func(t *testing.T) {
+		fields := structFields(reflect.TypeFor[shadowing]())
+		if assert.Len(t, fields, 1) {
+			assert.Equal(t, []int{1}, fields[0].index)
+		}
+	})
+
+	t.Run("tagged unexported embedded struct is a named field", func(t *testing.T) {
+		assert.Equal(t, []string{"in", "b"}, names(reflect.TypeFor[taggedEmbed]()))
+	})
+
+	t.Run("ambiguous embedded fields dropped", func(t *testing.T) {
+		assert.Empty(t, structFields(reflect.TypeFor[ambiguous]()))
+	})
+}
+
+func Test_structDocument(t *testing.T) {
+	t.Run("omitempty and nil embedded pointer fields omitted", func(t *testing.T) {
+		u := structUser{baseEntity: baseEntity{ID: 7}, Name: "Al", Status: "active", private: 1}
+		got := structDocument(reflect.ValueOf(u))
+		assert.Equal(t, jwalk.Document{
+			{Key: "id", Value: 7},
+			{Key: "name", Value: "Al"},
+			{Key: "status", Value: "active"},
+			{Key: "-", Value: ""},
+			{Key: "Untagged", Value: false},
+		}, got)
+	})
+
+	t.Run("tagged unexported embedded struct encodes its fields", func(t *testing.T) {
+		want := jwalk.Document{{Key: "in", Value: jwalk.Document{{Key: "a", Value: 1}}}, {Key: "b", Value: 2}}
+		assert.Equal(t, want, structDocument(reflect.ValueOf(taggedEmbed{inner: inner{A: 1}, B: 2})))
+		assert.Equal(t, want, structDocument(reflect.ValueOf(taggedEmbedPtr{inner: &inner{A: 1}, B: 2})))
+		assert.NoError(t, New().Test(Obj("b", 2), taggedEmbedPtr{B: 2}))
+		assert.NoError(t, New().Test(Obj("in", Obj("a", 1)), &taggedEmbed{inner: inner{A: 1}}))
+	})
+
+	t.Run("pointer fields dereferenced", func(t *testing.T) {
+		nick := "al"
+		u := structUser{Nickname: &nick, auditInfo: &auditInfo{By: "root"}}
+		doc := structDocument(reflect.ValueOf(u))
+		m := map[string]any{}
+		for _, e := range doc {
+			m[e.Key] = e.Value
+		}
+		assert.Equal(t, "al", m["nickname"])
+		assert.Equal(t, "root", m["By"])
+	})
+}
+
+func Test_structDocumentEncoding(t *testing.T) {
+	t.Run("fields encode like encoding/json", func(t *testing.T) {
+		ratio := 0.5
+		v := encoded{Data: []byte("x"), Count: 42, Label: "a<b", Ratio: &ratio, Price: money{cents: 1250}, Window: window{Start: 3}}
+		got := structDocument(reflect.ValueOf(v))
+		assert.Equal(t, jwalk.Document{
+			{Key: "data", Value: "eA=="},
+			{Key: "empty", Value: nil},
+			{Key: "count", Value: "42"},
+			{Key: "label", Value: `"a\u003cb"`},
+			{Key: "ratio", Value: "0.5"},
+			{Key: "price", Value: "12.50"},
+		}, got)
+	})
+
+	t.Run("omitzero uses IsZero", func(t *testing.T) {
+		v := encoded{Window: window{Len: 1}, Created: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+		doc := structDocument(reflect.ValueOf(v))
+		keys := make([]string, len(doc))
+		for i, e := range doc {
+			keys[i] = e.Key
+		}
+		assert.Subset(t, keys, []string{"window", "created"})
+	})
+}
+
+func Test_plainValue(t *testing.T) {
+	t.Run("named string converts", func(t *testing.T) {
+		assert.Equal(t, "x", plainValue(reflect.ValueOf(status("x"))))
+	})
+
+	t.Run("nil pointer returns nil", func(t *testing.T) {
+		assert.Nil(t, plainValue(reflect.ValueOf((*int)(nil))))
+	})
+
+	t.Run("pointer dereferences", func(t *testing.T) {
+		n := 3
+		assert.Equal(t, 3, plainValue(reflect.ValueOf(&n)))
+	})
+
+	t.Run("json marshaler decoded", func(t *testing.T) {
+		assert.Equal(t, "0.07", plainValue(reflect.ValueOf(money{cents: 7})))
+		assert.Equal(t, map[string]any{"a": 1.0}, plainValue(reflect.ValueOf(json.RawMessage(`{"a":1}`))))
+	})
+
+	t.Run("nil slice and map return nil", func(t *testing.T) {
+		assert.Equal(t, nil, plainValue(reflect.ValueOf([]string(nil))))
+		assert.Equal(t, nil, plainValue(reflect.ValueOf(map[string]any(nil))))
+	})
+
+	t.Run("text marshaler kept", func(t *testing.T) {
+		ts := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, ts, plainValue(reflect.ValueOf(ts)))
+	})
+}
+
+func TestTester_TestStruct(t *testing.T) {
+	type order struct {
+		ID        int       `json:"id"`
+		Items     []string  `json:"items"`
+		CreatedAt time.Time `json:"createdAt"`
+		User      *structUser
+	}
+	act := &order{
+		ID:        1,
+		Items:     []string{"a", "b"},
+		CreatedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		User:      &structUser{Name: "Al", Status: "active"},
+	}
+
+	t.Run("subset by json names succeeds", func(t *testing.T) {
+		exp := jwalk.Document{
+			{Key: "id", Value: 1},
+			{Key: "items", Value: jwalk.Array{"a", "b"}},
+			{Key: "createdAt", Value: "2024-01-01T00:00:00Z"},
+			{Key: "User", Value: jwalk.Document{{Key: "status", Value: "active"}}},
+		}
+		assert.NoError(t, New().Test(exp, act))
+	})
+
+	t.Run("mismatch reports json path", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "User", Value: jwalk.Document{{Key: "name", Value: "Bo"}}}}
+		err := New().Test(exp, act)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("User"), KeySegment("name")}, merr.Path)
+		}
+	})
+
+	t.Run("omitempty field is missing", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "User", Value: jwalk.Document{{Key: "nickname", Value: &Exists{want: false}}}}}
+		assert.NoError(t, New().Test(exp, act))
+	})
+
+	t.Run("bytes compare as base64 succeeds", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "data", Value: "eA=="}, {Key: "price", Value: "1.00"}}
+		assert.NoError(t, New().Test(exp, encoded{Data: []byte("x"), Price: money{cents: 100}}))
+		assert.Error(t, New().Test(exp, encoded{Data: []byte("y"), Price: money{cents: 100}}))
+	})
+
+	t.Run("strict equal rejects extra field", func(t *testing.T) {
+		exp := &Equal{expected: jwalk.Document{{Key: "id", Value: 1}}}
+		assert.Error(t, New().Test(exp, act))
+	})
+	t.Run("nil slice field compares as null", func(t *testing.T) {
+		v := struct {
+			Tags []string `json:"tags"`
+		}{}
+		assert.NoError(t, New().Test(jwalk.Document{{Key: "tags", Value: nil}}, v))
+		assert.Error(t, New().Test(jwalk.Document{{Key: "tags", Value: jwalk.Array{}}}, v))
+	})
+
+	t.Run("nil map field compares as null", func(t *testing.T) {
+		v := struct {
+			Meta map[string]any `json:"meta"`
+		}{}
+		assert.NoError(t, New().Test(jwalk.Document{{Key: "meta", Value: nil}}, v))
+		assert.Error(t, New().Test(jwalk.Document{{Key: "meta", Value: jwalk.Document{}}}, v))
+	})
+}
@@ -2,19 +2,157 @@ package testequals
 
 import (
 	"fmt"
+	"maps"
 	"reflect"
+	"slices"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/calumari/jwalk"
 )
 
 type cmpCtx struct {
-	path       []string
-	collect    bool
+	// base is the absolute path of the value this context compares, set for
+	// the nested comparisons started by rules; path is relative to it.
+	base    Path
+	path    Path
+	collect bool
+	objects ObjectMode
+	arrays  ArrayMode
+	ignore  []pathPattern
+	rules   []pathRule
+	// inRule is len(path)+1 while a Rule runs at the current path, and
+	// skipRootRule records that for the root of a nested context so path
+	// rules are not applied twice to the same value.
+	inRule       int
+	skipRootRule bool
+	// floats is the tolerance applied to numeric leaves; the zero value
+	// compares them exactly.
+	floats tolerance
+	// now reads the Tester's clock for time rules.
+	now func() time.Time
+	// captures holds the "$capture" bindings of the current Test call;
+	// comparisons that only try for a match bind into a staged child set.
+	captures *captureSet
+	// defs holds the definitions "$use" resolves names against. using lists
+	// the names being expanded at absolute depth useDepth, so that a
+	// definition referring to itself without descending is reported.
+	defs       *defScope
+	using      []string
+	useDepth   int
 	mismatches []*MismatchError
 }
 
+// nested returns a context for a comparison rooted at the current path, as run
+// by RuleContext.Test. Mismatches it records are relative to that path.
+func (c *cmpCtx) nested() *cmpCtx {
+	return &cmpCtx{
+		base:         c.absPath(),
+		collect:      c.collect,
+		objects:      c.objects,
+		arrays:       c.arrays,
+		ignore:       c.ignore,
+		rules:        c.rules,
+		skipRootRule: c.inRule == len(c.path)+1,
+		floats:       c.floats,
+		now:          c.now,
+		captures:     c.captures,
+		defs:         c.defs,
+		using:        c.using,
+		useDepth:     c.useDepth,
+	}
+}
+
+// trial returns a fail-fast context nested at the current path, used to probe
+// whether an expected element matches an actual one without reporting. Its
+// "$capture" bindings are staged until committed.
+func (c *cmpCtx) trial() *cmpCtx {
+	n := c.nested()
+	n.collect = false
+	n.captures = c.captures.child()
+	return n
+}
+
+// primitivesExact reports whether comparing two primitives reduces to
+// fastPrimitiveEqual, so that they may be matched by hashing. Ignored paths and
+// path rules may apply to any element and rule this out, as does a float
+// tolerance.
+func (c *cmpCtx) primitivesExact() bool {
+	return len(c.ignore) == 0 && len(c.rules) == 0 && c.floats == (tolerance{})
+}
+
+// absPath returns the absolute path of the current value.
+func (c *cmpCtx) absPath() Path {
+	return append(append(make(Path, 0, len(c.base)+len(c.path)), c.base...), c.path...)
+}
+
+// ignored reports whether the current value, or its descendant below segs,
+// matches an ignored path pattern.
+func (c *cmpCtx) ignored(segs ...PathSegment) bool {
+	if len(c.ignore) == 0 {
+		return false
+	}
+	p := append(c.absPath(), segs...)
+	for _, pat := range c.ignore {
+		if pat.match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathRule returns the first path rule matching the current value, or its
+// descendant below segs.
+func (c *cmpCtx) pathRule(segs ...PathSegment) (Rule, bool) {
+	if len(c.rules) == 0 || len(segs) == 0 && len(c.path) == 0 && c.skipRootRule {
+		return nil, false
+	}
+	p := append(c.absPath(), segs...)
+	for _, r := range c.rules {
+		if r.pattern.match(p) {
+			return r.rule, true
+		}
+	}
+	return nil, false
+}
+
+// withPathRules returns expected extended with entries for the keys it does
+// not list but a path rule applies to: keys of actual, in order, followed by
+// the literal trailing keys of the rule patterns (so a rule for a key missing
+// from both documents still runs, as for a missing expected key).
+func (c *cmpCtx) withPathRules(expected, actual jwalk.Document) jwalk.Document {
+	if len(c.rules) == 0 {
+		return expected
+	}
+	seen := make(map[string]bool, len(expected)+len(actual))
+	for _, e := range expected {
+		seen[e.Key] = true
+	}
+	var added jwalk.Document
+	add := func(k string) {
+		if seen[k] {
+			return
+		}
+		seen[k] = true
+		if r, ok := c.pathRule(keySeg(k)); ok {
+			added = append(added, jwalk.Entry{Key: k, Value: r})
+		}
+	}
+	for _, e := range actual {
+		add(e.Key)
+	}
+	for _, r := range c.rules {
+		if n := len(r.pattern); n > 0 && r.pattern[n-1].kind == patternKey {
+			add(r.pattern[n-1].key)
+		}
+	}
+	if len(added) == 0 {
+		return expected
+	}
+	return append(slices.Clip(expected), added...)
+}
+
 func (c *cmpCtx) report(m *MismatchError) error {
 	if c.collect {
 		c.mismatches = append(c.mismatches, m)
@@ -23,14 +161,16 @@ func (c *cmpCtx) report(m *MismatchError) error {
 	return m
 }
 
-func (c *cmpCtx) reportAt(seg, msg string) error {
+// reportAt reports m, whose path is relative to the current one, below the
+// child segment seg.
+func (c *cmpCtx) reportAt(seg PathSegment, m *MismatchError) error {
 	c.push(seg)
-	m := mismatch(c.path, msg)
+	m = m.at(c.path)
 	c.pop()
 	return c.report(m)
 }
 
-func (c *cmpCtx) push(seg string) {
+func (c *cmpCtx) push(seg PathSegment) {
 	c.path = append(c.path, seg)
 }
 
@@ -49,11 +189,52 @@ type TesterOptions struct {
 	// CollectAll causes Tester.Test to aggregate all mismatches and return a
 	// *MultiError instead of failing fast on the first *MismatchError.
 	CollectAll bool
+	// IgnorePaths lists path patterns (see WithIgnorePaths) whose nodes are
+	// skipped during comparison.
+	IgnorePaths []string
+	// ObjectMode is the default comparison mode for documents (subset unless
+	// set). Directives such as "$strict" and "$subset" override it locally.
+	ObjectMode ObjectMode
+	// ArrayMode is the default comparison mode for arrays (strict unless set).
+	// The "$mode" directive overrides it locally.
+	ArrayMode ArrayMode
+	// PathRules attaches rules to the nodes matching their patterns (see
+	// WithPathRule).
+	PathRules []PathRule
+	// FloatAbsTolerance and FloatRelTolerance allow numeric leaves to differ
+	// (see WithFloatTolerance). Negative values are coerced to 0 during Tester
+	// construction.
+	FloatAbsTolerance float64
+	FloatRelTolerance float64
+	// Definitions holds named expectations that "$use" can refer to from
+	// any expected value (see WithDefinition).
+	Definitions map[string]any
+	// Clock returns the current time for time rules such as {"$within":
+	// {"of": "now"}}. It defaults to time.Now.
+	Clock func() time.Time
+	// PersistCaptures keeps "$capture" bindings across calls to Tester.Test
+	// (see WithPersistentCaptures).
+	PersistCaptures bool
+}
+
+// PathRule attaches Rule to every node whose path matches Pattern, using the
+// syntax accepted by WithIgnorePaths.
+type PathRule struct {
+	Pattern string
+	Rule    Rule
+}
+
+type pathRule struct {
+	pattern pathPattern
+	rule    Rule
 }
 
 func DefaultConfig() TesterOptions {
 	return TesterOptions{
 		SmallDocLinearThreshold: 8,
+		ObjectMode:              ObjectSubset,
+		ArrayMode:               ArrayStrict,
+		Clock:                   time.Now,
 	}
 }
 
@@ -78,19 +259,125 @@ func WithCollectAll() TesterOption {
 	}
 }
 
+// WithObjectMode sets the default comparison mode for documents, e.g.
+// ObjectStrict for contract tests that must reject unknown fields.
+func WithObjectMode(m ObjectMode) TesterOption {
+	return func(c *TesterOptions) {
+		c.ObjectMode = m
+	}
+}
+
+// WithArrayMode sets the default comparison mode for arrays, e.g.
+// ArrayUnorderedSubset for lenient smoke tests.
+func WithArrayMode(m ArrayMode) TesterOption {
+	return func(c *TesterOptions) {
+		c.ArrayMode = m
+	}
+}
+
+// WithIgnorePaths skips every node whose path matches one of the patterns, in
+// subset comparisons as well as inside rules such as $eq, where an ignored key
+// may be missing or extra. Patterns use a glob or JSONPath-style syntax with an
+// optional leading "$": .name or ['name'] for keys, [n] for indices, * or [*]
+// for any single key or index and ** or .. for any depth, e.g.
+// "**.updatedAt", "$..etag" or "$.items[*].id". An invalid pattern is
+// reported by NewE, or by Tester.Test for a Tester built with New.
+func WithIgnorePaths(patterns ...string) TesterOption {
+	return func(c *TesterOptions) {
+		c.IgnorePaths = append(c.IgnorePaths, patterns...)
+	}
+}
+
+// WithPathRule attaches rule to every node whose path matches pattern (see
+// WithIgnorePaths for the syntax), so expectations such as golden fixtures can
+// stay plain data. The rule replaces the expected value at a matching path;
+// object keys the expected document does not list are checked too when they
+// are present in the actual document or named literally by the pattern's last
+// segment (e.g. "$.users[*].email" requires every user to have a matching
+// email unless the rule accepts a missing key). Rules only apply below
+// documents reached by the expected value, the first matching rule wins and
+// ignored paths take precedence. An invalid pattern is reported like one
+// given to WithIgnorePaths.
+func WithPathRule(pattern string, rule Rule) TesterOption {
+	return func(c *TesterOptions) {
+		c.PathRules = append(c.PathRules, PathRule{Pattern: pattern, Rule: rule})
+	}
+}
+
+// WithFloatTolerance lets every numeric leaf differ from the expected number
+// by up to abs, or by up to rel times the larger magnitude of the two, so that
+// values computed along different paths (e.g. 0.1+0.2 and 0.3) compare equal.
+// Use the "$approx" directive for a tolerance on individual values.
+func WithFloatTolerance(abs, rel float64) TesterOption {
+	return func(c *TesterOptions) {
+		c.FloatAbsTolerance = abs
+		c.FloatRelTolerance = rel
+	}
+}
+
+// WithDefinition registers expected under name for "$use", so that shared
+// shapes such as money or pagination blocks can be defined once in Go for
+// every test using the Tester. A "$defs" section in an expected document takes
+// precedence over definitions of the same name.
+func WithDefinition(name string, expected any) TesterOption {
+	return func(c *TesterOptions) {
+		if c.Definitions == nil {
+			c.Definitions = make(map[string]any)
+		}
+		c.Definitions[name] = expected
+	}
+}
+
+// WithClock sets the clock time rules read "now" from, so that assertions
+// such as {"$within": {"of": "now", "delta": "5s"}} are deterministic.
+func WithClock(clock func() time.Time) TesterOption {
+	return func(c *TesterOptions) {
+		c.Clock = clock
+	}
+}
+
+// WithPersistentCaptures keeps the "$capture" bindings of every successful
+// call to Tester.Test, so "$ref" can resolve them in later calls, e.g. to
+// assert on a value from one response in the next request of a multi-step
+// scenario. Without it, bindings are scoped to a single call (see
+// Tester.TestCaptures). Use a dedicated Tester per scenario, since its
+// bindings are shared by every caller.
+func WithPersistentCaptures() TesterOption {
+	return func(c *TesterOptions) {
+		c.PersistCaptures = true
+	}
+}
+
 // Tester performs comparisons between expected and actual values with subset
 // semantics for object nodes (jwalk.Document): every key present in the expected
 // document must exist and match in the actual; additional keys in the actual
 // document are ignored. Arrays (jwalk.Array) and primitive values are strict. To
 // enforce strict deep equality (rejecting extra object keys) for a subtree,
 // wrap the expected value with an Equal Rule (or use the "$eq" JSON rule).
+// The defaults for objects and arrays can be changed with WithObjectMode and
+// WithArrayMode, and overridden for a subtree with the Mode rule ("$strict",
+// "$subset" and "$mode").
+//
+// Expected containers must be jwalk values, while actual containers may also be
+// plain Go maps (string or integer keys) and slices/arrays, such as the
+// map[string]any and []any produced by encoding/json, or Go structs, which are
+// walked by their `json` field names without being serialized.
 //
 // A zero Tester must not be used; construct with New (or use the package level
 // Default / Test helpers). A Tester is safe for concurrent use by multiple
 // goroutines.
 type Tester struct {
 	options TesterOptions
+	ignore  []pathPattern
+	rules   []pathRule
+	defs    *defScope
 	mapPool sync.Pool
+	// err is the option error reported by Test when New was given invalid
+	// options.
+	err error
+
+	capturesMu sync.Mutex
+	captures   map[string]any
 }
 
 // defaultTester is a shared Tester using DefaultConfig. It is safe for concurrent
@@ -111,8 +398,20 @@ func SetDefaultTester(t *Tester) {
 }
 
 // New constructs a Tester applying the provided Option values. Invalid option
-// values (e.g. negative thresholds) are sanitized.
+// values (e.g. negative thresholds) are sanitized. Options that cannot be
+// sanitized, such as an invalid ignore path or path rule pattern, make every
+// call to Test return the error; use NewE to check for them up front.
 func New(opts ...TesterOption) *Tester {
+	t, err := NewE(opts...)
+	if err != nil {
+		return &Tester{err: err}
+	}
+	return t
+}
+
+// NewE is like New but returns an error for options that cannot be
+// sanitized, such as an invalid ignore path or path rule pattern.
+func NewE(opts ...TesterOption) (*Tester, error) {
 	cfg := DefaultConfig()
 	for _, opt := range opts {
 		opt(&cfg)
@@ -120,9 +419,37 @@ func New(opts ...TesterOption) *Tester {
 	if cfg.SmallDocLinearThreshold < 0 {
 		cfg.SmallDocLinearThreshold = 0
 	}
+	if cfg.ObjectMode == 0 {
+		cfg.ObjectMode = ObjectSubset
+	}
+	if cfg.ArrayMode == 0 {
+		cfg.ArrayMode = ArrayStrict
+	}
+	cfg.FloatAbsTolerance = max(cfg.FloatAbsTolerance, 0)
+	cfg.FloatRelTolerance = max(cfg.FloatRelTolerance, 0)
+	if cfg.Clock == nil {
+		cfg.Clock = time.Now
+	}
 	t := &Tester{options: cfg}
+	if len(cfg.Definitions) > 0 {
+		t.defs = &defScope{defs: cfg.Definitions}
+	}
+	for _, p := range cfg.IgnorePaths {
+		pat, err := parsePathPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		t.ignore = append(t.ignore, pat)
+	}
+	for _, r := range cfg.PathRules {
+		pat, err := parsePathPattern(r.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		t.rules = append(t.rules, pathRule{pattern: pat, rule: r.Rule})
+	}
 	t.mapPool.New = func() any { return make(map[string]any) }
-	return t
+	return t, nil
 }
 
 // Test is a convenience wrapper that delegates to Default.Test.
@@ -133,9 +460,89 @@ func Test(expected, actual any) error {
 // Test compares expected against actual using the Tester's semantics. On the
 // first mismatch it returns a *MismatchError unless CollectAll is enabled, in
 // which case all mismatches are aggregated and returned as *MultiError. The
-// returned error is nil when actual satisfies (is a superset of) expected.
+// returned error is nil when actual satisfies (is a superset of) expected. A
+// Tester constructed with invalid options returns the option error instead.
 func (t *Tester) Test(expected, actual any) error {
-	ctx := &cmpCtx{collect: t.options.CollectAll}
+	_, err := t.compareTop(expected, actual)
+	return err
+}
+
+// TestCaptures is like Test but also returns the values bound by "$capture"
+// during the call, by name, so they can be reused in the next request of a
+// multi-step scenario. The bindings are nil when the comparison fails. They
+// belong to this call alone, so callers sharing a Tester never see each
+// other's values.
+func (t *Tester) TestCaptures(expected, actual any) (map[string]any, error) {
+	caps, err := t.compareTop(expected, actual)
+	if err != nil {
+		return nil, err
+	}
+	return maps.Clone(caps.bound), nil
+}
+
+// compareTop runs a top-level comparison and returns the "$capture" bindings it
+// made.
+func (t *Tester) compareTop(expected, actual any) (*captureSet, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	caps := &captureSet{}
+	if t.options.PersistCaptures {
+		caps.prior = t.Captures()
+	}
+	err := t.run(&cmpCtx{
+		collect:  t.options.CollectAll,
+		objects:  t.options.ObjectMode,
+		arrays:   t.options.ArrayMode,
+		ignore:   t.ignore,
+		rules:    t.rules,
+		floats:   tolerance{abs: t.options.FloatAbsTolerance, rel: t.options.FloatRelTolerance},
+		now:      t.options.Clock,
+		captures: caps,
+		defs:     t.defs,
+	}, expected, actual)
+	if err == nil && t.options.PersistCaptures {
+		t.keepCaptures(caps)
+	}
+	return caps, err
+}
+
+// Captures returns a copy of the values bound by "$capture" during previous
+// successful calls to Test, by name, for a Tester built with
+// WithPersistentCaptures; it is empty otherwise. A later capture under the
+// same name replaces a binding. "$ref" resolves names against them, so values
+// captured from one response can be asserted on in the next.
+func (t *Tester) Captures() map[string]any {
+	t.capturesMu.Lock()
+	defer t.capturesMu.Unlock()
+	return maps.Clone(t.captures)
+}
+
+// ResetCaptures discards every binding made by "$capture".
+func (t *Tester) ResetCaptures() {
+	t.capturesMu.Lock()
+	defer t.capturesMu.Unlock()
+	t.captures = nil
+}
+
+func (t *Tester) keepCaptures(caps *captureSet) {
+	if len(caps.bound) == 0 {
+		return
+	}
+	t.capturesMu.Lock()
+	defer t.capturesMu.Unlock()
+	if t.captures == nil {
+		t.captures = make(map[string]any, len(caps.bound))
+	}
+	maps.Copy(t.captures, caps.bound)
+}
+
+// testNested runs a nested comparison on behalf of a rule evaluated in parent.
+func (t *Tester) testNested(parent *cmpCtx, expected, actual any) error {
+	return t.run(parent.nested(), expected, actual)
+}
+
+func (t *Tester) run(ctx *cmpCtx, expected, actual any) error {
 	if err := t.test(ctx, expected, actual); err != nil {
 		return err
 	}
@@ -146,48 +553,98 @@ func (t *Tester) Test(expected, actual any) error {
 }
 
 func (t *Tester) test(ctx *cmpCtx, expected, actual any) error {
+	if ctx.ignored() {
+		return nil
+	}
+	if r, ok := ctx.pathRule(); ok {
+		expected = r
+	}
 	switch exp := expected.(type) {
 	case jwalk.Document:
-		actDoc, ok := actual.(jwalk.Document)
+		actDoc, ok := asDocument(actual)
 		if !ok {
-			return ctx.report(mismatch(ctx.path, fmt.Sprintf("expected jwalk.Document, got %T", actual)))
+			return ctx.report(newMismatch(TypeMismatch, exp, actual, fmt.Sprintf("expected jwalk.Document, got %T", actual)).at(ctx.path))
+		}
+		exp = ctx.withPathRules(exp, actDoc)
+		if err := t.compareDocument(ctx, exp, actDoc); err != nil {
+			return err
+		}
+		if ctx.objects == ObjectStrict {
+			return t.compareExtraKeys(ctx, exp, actDoc)
 		}
-		return t.compareDocument(ctx, exp, actDoc)
+		return nil
 	case jwalk.Array:
-		actArr, ok := actual.(jwalk.Array)
+		actArr, ok := asArray(actual)
 		if !ok {
-			return ctx.report(mismatch(ctx.path, fmt.Sprintf("expected jwalk.Array, got %T", actual)))
+			return ctx.report(newMismatch(TypeMismatch, exp, actual, fmt.Sprintf("expected jwalk.Array, got %T", actual)).at(ctx.path))
 		}
 		return t.compareArray(ctx, exp, actArr)
 	case Rule:
-		if err := exp.Test(&RuleContext{runner: t, inner: ctx}, actual); err != nil {
-			if merr, ok := err.(*MismatchError); ok {
-				return ctx.report(mismatch(append(ctx.path, merr.Path...), merr.Message))
-			}
-			if multi, ok := err.(*MultiError); ok {
-				for _, m := range multi.Mismatches {
-					if r := ctx.report(mismatch(append(ctx.path, m.Path...), m.Message)); r != nil {
-						return r
-					}
-				}
-				return nil
-			}
-			return ctx.report(mismatch(ctx.path, err.Error()))
+		prev := ctx.inRule
+		ctx.inRule = len(ctx.path) + 1
+		err := exp.Test(newRuleContext(t, ctx), actual)
+		ctx.inRule = prev
+		if err != nil {
+			return t.reportRuleError(ctx, err)
 		}
 		return nil
 	default:
+		actual = leafValue(expected, actual)
 		handled, err := fastPrimitiveEqual(expected, actual)
+		if err != nil && ctx.floats != (tolerance{}) {
+			if ok, terr := numericClose(expected, actual, ctx.floats); ok {
+				err = terr
+			}
+		}
 		if err != nil {
-			return ctx.report(mismatch(ctx.path, err.Error()))
+			return ctx.report(err.(*MismatchError).at(ctx.path))
 		}
 		if handled {
 			return nil
 		}
 		if !reflect.DeepEqual(expected, actual) {
-			return ctx.report(mismatch(ctx.path, fmt.Sprintf("expected (%T)%v, got (%T)%v", expected, expected, actual, actual)))
+			kind := ValueMismatch
+			if reflect.TypeOf(expected) != reflect.TypeOf(actual) {
+				kind = TypeMismatch
+			}
+			return ctx.report(newMismatch(kind, expected, actual, fmt.Sprintf("expected (%T)%v, got (%T)%v", expected, expected, actual, actual)).at(ctx.path))
+		}
+		return nil
+	}
+}
+
+// reportRuleError records an error returned by a Rule, prefixing any relative
+// mismatch paths with the current path. Errors that are not mismatches are
+// reported as RuleFailed and remain reachable through MismatchError.Err.
+func (t *Tester) reportRuleError(ctx *cmpCtx, err error) error {
+	for _, m := range mismatchesOf(err) {
+		if r := ctx.report(m.at(ctx.path)); r != nil {
+			return r
 		}
+	}
+	return nil
+}
+
+// testMissing handles an expected key that is absent from the actual document.
+// Rules implementing AbsentRule decide the outcome themselves; any other
+// expected value is reported as "key not found".
+func (t *Tester) testMissing(ctx *cmpCtx, key string, expected any) error {
+	if ctx.ignored(keySeg(key)) {
 		return nil
 	}
+	if r, ok := ctx.pathRule(keySeg(key)); ok {
+		expected = r
+	}
+	ar, ok := expected.(AbsentRule)
+	if !ok {
+		return ctx.reportAt(keySeg(key), newMismatch(KeyNotFound, expected, nil, "key not found"))
+	}
+	ctx.push(keySeg(key))
+	defer ctx.pop()
+	if err := ar.TestAbsent(newRuleContext(t, ctx)); err != nil {
+		return t.reportRuleError(ctx, err)
+	}
+	return nil
 }
 
 func (t *Tester) compareDocument(ctx *cmpCtx, expected jwalk.Document, actual jwalk.Document) error {
@@ -207,7 +664,7 @@ func (t *Tester) compareDocument(ctx *cmpCtx, expected jwalk.Document, actual jw
 				}
 			}
 			if !found {
-				if err := ctx.reportAt(keySeg(expEntry.Key), "key not found"); err != nil {
+				if err := t.testMissing(ctx, expEntry.Key, expEntry.Value); err != nil {
 					return err
 				}
 			}
@@ -225,7 +682,7 @@ func (t *Tester) compareDocument(ctx *cmpCtx, expected jwalk.Document, actual jw
 	for _, entry := range expected {
 		actVal, exists := m[entry.Key]
 		if !exists {
-			if err := ctx.reportAt(keySeg(entry.Key), "key not found"); err != nil {
+			if err := t.testMissing(ctx, entry.Key, entry.Value); err != nil {
 				return err
 			}
 			continue
@@ -240,9 +697,53 @@ func (t *Tester) compareDocument(ctx *cmpCtx, expected jwalk.Document, actual jw
 	return nil
 }
 
+// compareExtraKeys reports the keys of actual that expected does not list, as
+// required by ObjectStrict. Ignored keys are skipped.
+func (t *Tester) compareExtraKeys(ctx *cmpCtx, expected jwalk.Document, actual jwalk.Document) error {
+	listed := func(k string) bool {
+		return slices.ContainsFunc(expected, func(e jwalk.Entry) bool { return e.Key == k })
+	}
+	if len(expected) > t.options.SmallDocLinearThreshold {
+		keys := make(map[string]struct{}, len(expected))
+		for _, e := range expected {
+			keys[e.Key] = struct{}{}
+		}
+		listed = func(k string) bool {
+			_, ok := keys[k]
+			return ok
+		}
+	}
+	for _, e := range actual {
+		if listed(e.Key) || ctx.ignored(keySeg(e.Key)) {
+			continue
+		}
+		if err := ctx.reportAt(keySeg(e.Key), newMismatch(UnexpectedKey, nil, e.Value, fmt.Sprintf("unexpected extra key %q (strict mode)", e.Key))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (t *Tester) compareArray(ctx *cmpCtx, expected jwalk.Array, actual jwalk.Array) error {
-	if len(expected) != len(actual) {
-		return ctx.report(mismatch(ctx.path, fmt.Sprintf("length mismatch: expected %d, got %d", len(expected), len(actual))))
+	switch ctx.arrays {
+	case ArrayOrderedPrefix:
+		if len(actual) < len(expected) {
+			return ctx.report(newMismatch(LengthMismatch, len(expected), len(actual), fmt.Sprintf("length mismatch: expected at least %d, got %d", len(expected), len(actual))).at(ctx.path))
+		}
+	case ArrayUnordered:
+		if len(expected) != len(actual) {
+			return ctx.report(newMismatch(LengthMismatch, len(expected), len(actual), fmt.Sprintf("length mismatch: expected %d, got %d", len(expected), len(actual))).at(ctx.path))
+		}
+		return t.compareUnordered(ctx, expected, actual)
+	case ArrayUnorderedSubset:
+		if len(actual) < len(expected) {
+			return ctx.report(newMismatch(LengthMismatch, len(expected), len(actual), fmt.Sprintf("length mismatch: expected at least %d, got %d", len(expected), len(actual))).at(ctx.path))
+		}
+		return t.compareUnordered(ctx, expected, actual)
+	default:
+		if len(expected) != len(actual) {
+			return ctx.report(newMismatch(LengthMismatch, len(expected), len(actual), fmt.Sprintf("length mismatch: expected %d, got %d", len(expected), len(actual))).at(ctx.path))
+		}
 	}
 	for i := range expected {
 		ctx.push(indexSeg(i))
@@ -254,3 +755,46 @@ func (t *Tester) compareArray(ctx *cmpCtx, expected jwalk.Array, actual jwalk.Ar
 	}
 	return nil
 }
+
+// compareUnordered matches every expected element against a distinct actual
+// element regardless of order, reporting each expected element left without a
+// match at the array's path.
+func (t *Tester) compareUnordered(ctx *cmpCtx, expected jwalk.Array, actual jwalk.Array) error {
+	matched, ok := []int(nil), false
+	if ctx.primitivesExact() {
+		matched, ok = matchPrimitives(expected, actual)
+	}
+	if !ok {
+		staged := make(map[[2]int]*captureSet)
+		matched = matchElements(len(expected), len(actual), func(ei, ai int) error {
+			ctx.push(indexSeg(ai))
+			defer ctx.pop()
+			trial := ctx.trial()
+			staged[[2]int{ei, ai}] = trial.captures
+			return t.run(trial, expected[ei], actual[ai])
+		})
+		if !slices.Contains(matched, -1) {
+			for ei, ai := range matched {
+				ctx.push(indexSeg(ai))
+				err := newRuleContext(t, ctx).commitCaptures(staged[[2]int{ei, ai}])
+				if err != nil {
+					err = t.reportRuleError(ctx, err)
+				}
+				ctx.pop()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	for ei, ai := range matched {
+		if ai >= 0 {
+			continue
+		}
+		m := newMismatch(ValueMismatch, expected[ei], actual, fmt.Sprintf("no matching element for expected element %d (%v)", ei, expected[ei]))
+		if err := ctx.report(m.at(ctx.path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,10 +1,17 @@
 package testequals
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/calumari/jwalk"
+	"github.com/go-json-experiment/json"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockRule struct {
@@ -88,6 +95,57 @@ func TestTester_Test(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("absent rule on missing key linear scan succeeds", func(t *testing.T) {
+		tester := New()
+		exp := jwalk.Document{{Key: "a", Value: 1}, {Key: "b", Value: &Exists{want: false}}}
+		act := jwalk.Document{{Key: "a", Value: 1}}
+		err := tester.Test(exp, act)
+		assert.NoError(t, err)
+	})
+
+	t.Run("absent rule on present key linear scan returns error", func(t *testing.T) {
+		tester := New()
+		exp := jwalk.Document{{Key: "b", Value: &Exists{want: false}}}
+		act := jwalk.Document{{Key: "b", Value: nil}}
+		err := tester.Test(exp, act)
+		assert.ErrorIs(t, err, ErrKeyPresent)
+	})
+
+	t.Run("absent rule on missing key succeeds", func(t *testing.T) {
+		tester := New(WithLinearScanThreshold(0))
+		exp := jwalk.Document{{Key: "a", Value: 1}, {Key: "b", Value: &Exists{want: false}}}
+		act := jwalk.Document{{Key: "a", Value: 1}}
+		err := tester.Test(exp, act)
+		assert.NoError(t, err)
+	})
+
+	t.Run("absent rule failure on missing key reports key path", func(t *testing.T) {
+		tester := New(WithLinearScanThreshold(0))
+		exp := jwalk.Document{{Key: "b", Value: &Exists{want: true}}}
+		act := jwalk.Document{{Key: "a", Value: 1}}
+		err := tester.Test(exp, act)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("b")}, merr.Path)
+		}
+	})
+
+	t.Run("optional rule on missing key succeeds", func(t *testing.T) {
+		tester := New()
+		exp := jwalk.Document{{Key: "nickname", Value: &Optional{expected: "al"}}}
+		act := jwalk.Document{{Key: "name", Value: "Alice"}}
+		err := tester.Test(exp, act)
+		assert.NoError(t, err)
+	})
+
+	t.Run("optional rule on present mismatching key returns error", func(t *testing.T) {
+		tester := New()
+		exp := jwalk.Document{{Key: "nickname", Value: &Optional{expected: "al"}}}
+		act := jwalk.Document{{Key: "nickname", Value: "bob"}}
+		err := tester.Test(exp, act)
+		assert.Error(t, err)
+	})
+
 	t.Run("array equal succeeds", func(t *testing.T) {
 		tester := New()
 		exp := jwalk.Array{1, 2, 3}
@@ -128,6 +186,56 @@ func TestTester_Test(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("map actual subset succeeds", func(t *testing.T) {
+		tester := New()
+		exp := jwalk.Document{{Key: "a", Value: 1}, {Key: "tags", Value: jwalk.Array{"x", "y"}}}
+		act := map[string]any{"a": float64(1), "b": 2, "tags": []any{"x", "y"}}
+		err := tester.Test(exp, act)
+		assert.NoError(t, err)
+	})
+
+	t.Run("typed map and slice actual succeeds", func(t *testing.T) {
+		tester := New()
+		exp := jwalk.Document{{Key: "ids", Value: jwalk.Array{1, 2}}}
+		act := map[string][]int{"ids": {1, 2}}
+		err := tester.Test(exp, act)
+		assert.NoError(t, err)
+	})
+
+	t.Run("nested map actual mismatch reports path", func(t *testing.T) {
+		tester := New()
+		exp := jwalk.Document{{Key: "items", Value: jwalk.Array{jwalk.Document{{Key: "id", Value: 1}}}}}
+		act := map[string]any{"items": []any{map[string]any{"id": 2}}}
+		err := tester.Test(exp, act)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("items"), IndexSegment(0), KeySegment("id")}, merr.Path)
+		}
+	})
+
+	t.Run("numeric rules accept pointer and named numbers in maps", func(t *testing.T) {
+		type count int
+		n := 3
+		act := map[string]any{"n": &n, "c": count(4), "tags": &[]string{"a"}}
+		assert.NoError(t, New().Test(Obj("n", Gt(2), "c", Approx(4, 0, 0), "tags", Len(1)), act))
+	})
+
+	t.Run("map actual missing key returns error", func(t *testing.T) {
+		tester := New()
+		exp := jwalk.Document{{Key: "a", Value: 1}}
+		act := map[string]any{"b": 1}
+		err := tester.Test(exp, act)
+		assert.Error(t, err)
+	})
+
+	t.Run("strict equal rejects extra map key", func(t *testing.T) {
+		tester := New()
+		exp := &Equal{expected: jwalk.Document{{Key: "a", Value: 1}}}
+		act := map[string]any{"a": 1, "b": 2}
+		err := tester.Test(exp, act)
+		assert.Error(t, err)
+	})
+
 	t.Run("Rule success returns nil", func(t *testing.T) {
 		tester := New()
 		rule := &mockRule{err: nil}
@@ -142,3 +250,1016 @@ func TestTester_Test(t *testing.T) {
 		assert.Error(t, err)
 	})
 }
+
+func TestTester_TestMismatchDetails(t *testing.T) {
+	asMismatch := func(t *testing.T, err error) *MismatchError {
+		t.Helper()
+		var merr *MismatchError
+		if !assert.ErrorAs(t, err, &merr) {
+			t.FailNow()
+		}
+		return merr
+	}
+
+	t.Run("missing key reports KeyNotFound", func(t *testing.T) {
+		merr := asMismatch(t, New().Test(jwalk.Document{{Key: "a", Value: 1}}, jwalk.Document{}))
+		assert.Equal(t, KeyNotFound, merr.Kind)
+		assert.Equal(t, 1, merr.Expected)
+		assert.Nil(t, merr.Actual)
+	})
+
+	t.Run("container type reports TypeMismatch", func(t *testing.T) {
+		merr := asMismatch(t, New().Test(jwalk.Array{1}, "x"))
+		assert.Equal(t, TypeMismatch, merr.Kind)
+		assert.Equal(t, "x", merr.Actual)
+	})
+
+	t.Run("primitive type reports TypeMismatch", func(t *testing.T) {
+		merr := asMismatch(t, New().Test("5", 5))
+		assert.Equal(t, TypeMismatch, merr.Kind)
+		assert.Equal(t, "5", merr.Expected)
+		assert.Equal(t, 5, merr.Actual)
+	})
+
+	t.Run("primitive value reports ValueMismatch", func(t *testing.T) {
+		merr := asMismatch(t, New().Test(jwalk.Document{{Key: "a", Value: 5}}, jwalk.Document{{Key: "a", Value: 6}}))
+		assert.Equal(t, ValueMismatch, merr.Kind)
+		assert.Equal(t, Path{KeySegment("a")}, merr.Path)
+		assert.Equal(t, 5, merr.Expected)
+		assert.Equal(t, 6, merr.Actual)
+		assert.Empty(t, merr.Rule)
+	})
+
+	t.Run("array length reports LengthMismatch", func(t *testing.T) {
+		merr := asMismatch(t, New().Test(jwalk.Array{1, 2}, jwalk.Array{1}))
+		assert.Equal(t, LengthMismatch, merr.Kind)
+		assert.Equal(t, 2, merr.Expected)
+		assert.Equal(t, 1, merr.Actual)
+	})
+
+	t.Run("strict nested value mismatch reports full path", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "u", Value: &Equal{expected: jwalk.Document{{Key: "a", Value: 1}}}}}
+		merr := asMismatch(t, New().Test(exp, jwalk.Document{{Key: "u", Value: jwalk.Document{{Key: "a", Value: 2}}}}))
+		assert.Equal(t, ValueMismatch, merr.Kind)
+		assert.Equal(t, Path{KeySegment("u"), KeySegment("a")}, merr.Path)
+	})
+
+	t.Run("strict extra key reports UnexpectedKey", func(t *testing.T) {
+		exp := &Equal{expected: jwalk.Document{{Key: "a", Value: 1}}}
+		merr := asMismatch(t, New().Test(exp, jwalk.Document{{Key: "a", Value: 1}, {Key: "b", Value: 2}}))
+		assert.Equal(t, UnexpectedKey, merr.Kind)
+		assert.Equal(t, "$eq", merr.Rule)
+		assert.Equal(t, Path{KeySegment("b")}, merr.Path)
+		assert.Equal(t, 2, merr.Actual)
+	})
+
+	t.Run("builtin rule reports RuleFailed with directive", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "n", Value: &numericCompare{op: "gt", ref: 10, incl: true}}}
+		merr := asMismatch(t, New().Test(exp, jwalk.Document{{Key: "n", Value: 3}}))
+		assert.Equal(t, RuleFailed, merr.Kind)
+		assert.Equal(t, "$gte", merr.Rule)
+		assert.Equal(t, float64(10), merr.Expected)
+		assert.Equal(t, 3, merr.Actual)
+		assert.Equal(t, Path{KeySegment("n")}, merr.Path)
+	})
+
+	t.Run("custom rule error reports RuleFailed and unwraps", func(t *testing.T) {
+		merr := asMismatch(t, New().Test(&mockRule{err: assert.AnError}, 1))
+		assert.Equal(t, RuleFailed, merr.Kind)
+		assert.ErrorIs(t, merr, assert.AnError)
+	})
+}
+
+func TestTester_TestCollectLogical(t *testing.T) {
+	t.Run("and failure is reported", func(t *testing.T) {
+		err := New(WithCollectAll()).Test(&And{rules: []any{1, 2}}, 1)
+		var multi *MultiError
+		if assert.ErrorAs(t, err, &multi) {
+			assert.Len(t, multi.Mismatches, 1)
+		}
+	})
+
+	t.Run("or failure reports every alternative", func(t *testing.T) {
+		err := New(WithCollectAll()).Test(&Or{rules: []any{2, 3}}, 1)
+		var multi *MultiError
+		if assert.ErrorAs(t, err, &multi) {
+			assert.Len(t, multi.Mismatches, 2)
+		}
+	})
+
+	t.Run("nor matches are not duplicated", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "a", Value: 2}, {Key: "b", Value: &Nor{rules: []any{1}}}}
+		err := New(WithCollectAll()).Test(exp, jwalk.Document{{Key: "a", Value: 1}, {Key: "b", Value: 1}})
+		var multi *MultiError
+		if assert.ErrorAs(t, err, &multi) {
+			assert.Len(t, multi.Mismatches, 2)
+		}
+	})
+}
+
+func TestTester_TestIgnorePaths(t *testing.T) {
+	t.Run("ignored value mismatch succeeds", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "id", Value: 1}, {Key: "updatedAt", Value: "x"}}
+		act := jwalk.Document{{Key: "id", Value: 1}, {Key: "updatedAt", Value: "y"}}
+		assert.NoError(t, New(WithIgnorePaths("updatedAt")).Test(exp, act))
+	})
+
+	t.Run("ignored missing key succeeds", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "id", Value: 1}, {Key: "etag", Value: "x"}}
+		assert.NoError(t, New(WithIgnorePaths("$.etag")).Test(exp, jwalk.Document{{Key: "id", Value: 1}}))
+	})
+
+	t.Run("descendant pattern ignores every depth", func(t *testing.T) {
+		exp := jwalk.Document{
+			{Key: "updatedAt", Value: 1},
+			{Key: "items", Value: jwalk.Array{jwalk.Document{{Key: "updatedAt", Value: 1}, {Key: "name", Value: "a"}}}},
+		}
+		act := jwalk.Document{
+			{Key: "updatedAt", Value: 2},
+			{Key: "items", Value: jwalk.Array{jwalk.Document{{Key: "updatedAt", Value: 2}, {Key: "name", Value: "a"}}}},
+		}
+		assert.NoError(t, New(WithIgnorePaths("**.updatedAt")).Test(exp, act))
+	})
+
+	t.Run("wildcard index pattern ignores array elements field", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "items", Value: jwalk.Array{
+			jwalk.Document{{Key: "id", Value: 1}, {Key: "name", Value: "a"}},
+			jwalk.Document{{Key: "id", Value: 2}, {Key: "name", Value: "b"}},
+		}}}
+		act := jwalk.Document{{Key: "items", Value: jwalk.Array{
+			jwalk.Document{{Key: "id", Value: 7}, {Key: "name", Value: "a"}},
+			jwalk.Document{{Key: "id", Value: 8}, {Key: "name", Value: "b"}},
+		}}}
+		assert.NoError(t, New(WithIgnorePaths("$.items[*].id")).Test(exp, act))
+	})
+
+	t.Run("non-ignored mismatch returns error", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "id", Value: 1}, {Key: "updatedAt", Value: "x"}}
+		act := jwalk.Document{{Key: "id", Value: 2}, {Key: "updatedAt", Value: "y"}}
+		err := New(WithIgnorePaths("updatedAt")).Test(exp, act)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("id")}, merr.Path)
+		}
+	})
+
+	t.Run("strict equal skips ignored extra and mismatching keys", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "user", Value: &Equal{expected: jwalk.Document{
+			{Key: "name", Value: "Alice"},
+			{Key: "createdAt", Value: "x"},
+		}}}}
+		act := jwalk.Document{{Key: "user", Value: jwalk.Document{
+			{Key: "name", Value: "Alice"},
+			{Key: "createdAt", Value: "y"},
+			{Key: "requestId", Value: "r-1"},
+		}}}
+		assert.NoError(t, New(WithIgnorePaths("**.createdAt", "$..requestId")).Test(exp, act))
+	})
+
+	t.Run("strict equal reports non-ignored extra key", func(t *testing.T) {
+		exp := &Equal{expected: jwalk.Document{{Key: "name", Value: "Alice"}}}
+		act := jwalk.Document{{Key: "name", Value: "Alice"}, {Key: "requestId", Value: "r-1"}, {Key: "extra", Value: 1}}
+		err := New(WithIgnorePaths("requestId")).Test(exp, act)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("extra")}, merr.Path)
+		}
+	})
+
+	t.Run("invalid pattern returns error", func(t *testing.T) {
+		tester, err := NewE(WithIgnorePaths("a["))
+		assert.ErrorContains(t, err, `invalid path pattern "a["`)
+		assert.Nil(t, tester)
+		assert.ErrorContains(t, New(WithIgnorePaths("a[")).Test(1, 1), `invalid path pattern "a["`)
+	})
+}
+
+func TestTester_TestPathRules(t *testing.T) {
+	users := func(emails ...any) jwalk.Document {
+		arr := jwalk.Array{}
+		for _, e := range emails {
+			arr = append(arr, jwalk.Document{{Key: "name", Value: "u"}, {Key: "email", Value: e}})
+		}
+		return jwalk.Document{{Key: "users", Value: arr}}
+	}
+
+	t.Run("rule replaces literal expected value succeeds", func(t *testing.T) {
+		tester := New(WithPathRule("$.users[*].email", Regex(`@example\.com$`)))
+		assert.NoError(t, tester.Test(users("fixture@example.com"), users("alice@example.com")))
+	})
+
+	t.Run("replacing rule failure reports path", func(t *testing.T) {
+		tester := New(WithPathRule("$.users[*].email", Regex(`@example\.com$`)))
+		err := tester.Test(users("a@example.com", "b@example.com"), users("a@example.com", "b@other.org"))
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("users"), IndexSegment(1), KeySegment("email")}, merr.Path)
+			assert.Equal(t, "$regex", merr.Rule)
+		}
+	})
+
+	t.Run("rule is added for key missing from expected", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "users", Value: jwalk.Array{jwalk.Document{{Key: "name", Value: "u"}}}}}
+		tester := New(WithPathRule("$.users[*].email", Regex(`@example\.com$`)))
+		assert.NoError(t, tester.Test(exp, users("alice@example.com")))
+		assert.Error(t, tester.Test(exp, users("alice@other.org")))
+	})
+
+	t.Run("added rule for key missing from both returns error", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "name", Value: "u"}}
+		tester := New(WithPathRule("email", Regex(`@`)))
+		err := tester.Test(exp, jwalk.Document{{Key: "name", Value: "u"}})
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, KeyNotFound, merr.Kind)
+			assert.Equal(t, Path{KeySegment("email")}, merr.Path)
+		}
+	})
+
+	t.Run("absent rule accepts key missing from both", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "name", Value: "u"}}
+		tester := New(WithPathRule("nickname", IfPresent(Regex(`^[a-z]+$`))))
+		assert.NoError(t, tester.Test(exp, jwalk.Document{{Key: "name", Value: "u"}}))
+	})
+
+	t.Run("nested rule at same path is not reapplied", func(t *testing.T) {
+		tester := New(WithPathRule("$.n", AllOf(Gte(1), Lt(10))))
+		assert.NoError(t, tester.Test(jwalk.Document{{Key: "n", Value: 0}}, jwalk.Document{{Key: "n", Value: 5}}))
+		assert.Error(t, tester.Test(jwalk.Document{{Key: "n", Value: 5}}, jwalk.Document{{Key: "n", Value: 50}}))
+	})
+
+	t.Run("strict equal applies rules to its keys", func(t *testing.T) {
+		exp := Eq(jwalk.Document{{Key: "id", Value: "fixture"}})
+		tester := New(WithPathRule("id", Anything()), WithPathRule("etag", Anything()))
+		assert.NoError(t, tester.Test(exp, jwalk.Document{{Key: "id", Value: "x"}, {Key: "etag", Value: "y"}}))
+		assert.Error(t, tester.Test(exp, jwalk.Document{{Key: "id", Value: "x"}, {Key: "other", Value: "y"}}))
+	})
+
+	t.Run("ignored path takes precedence", func(t *testing.T) {
+		tester := New(WithIgnorePaths("email"), WithPathRule("email", Regex(`@`)))
+		assert.NoError(t, tester.Test(jwalk.Document{}, jwalk.Document{{Key: "email", Value: "nope"}}))
+	})
+
+	t.Run("invalid pattern returns error", func(t *testing.T) {
+		_, err := NewE(WithPathRule("$.users[x]", Anything()))
+		assert.ErrorContains(t, err, `invalid path pattern "$.users[x]"`)
+		assert.ErrorContains(t, New(WithPathRule("$.users[x]", Anything())).Test(1, 1), `invalid path pattern "$.users[x]"`)
+	})
+}
+
+func TestTester_TestModes(t *testing.T) {
+	t.Run("strict objects reject extra key", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "user", Value: jwalk.Document{{Key: "id", Value: 1}}}}
+		act := jwalk.Document{{Key: "user", Value: jwalk.Document{{Key: "id", Value: 1}, {Key: "extra", Value: true}}}}
+		err := New(WithObjectMode(ObjectStrict)).Test(exp, act)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, UnexpectedKey, merr.Kind)
+			assert.Equal(t, Path{KeySegment("user"), KeySegment("extra")}, merr.Path)
+		}
+	})
+
+	t.Run("strict objects collect every extra key", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "id", Value: 1}}
+		act := jwalk.Document{{Key: "id", Value: 1}, {Key: "a", Value: 1}, {Key: "b", Value: 2}}
+		err := New(WithObjectMode(ObjectStrict), WithCollectAll()).Test(exp, act)
+		var multi *MultiError
+		if assert.ErrorAs(t, err, &multi) {
+			assert.Len(t, multi.Mismatches, 2)
+		}
+	})
+
+	t.Run("strict objects skip ignored keys", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "id", Value: 1}}
+		act := jwalk.Document{{Key: "id", Value: 1}, {Key: "etag", Value: "x"}}
+		assert.NoError(t, New(WithObjectMode(ObjectStrict), WithIgnorePaths("etag")).Test(exp, act))
+	})
+
+	t.Run("subset directive under strict default succeeds", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "id", Value: 1}, {Key: "meta", Value: Subset(jwalk.Document{{Key: "v", Value: 1}})}}
+		act := jwalk.Document{{Key: "id", Value: 1}, {Key: "meta", Value: jwalk.Document{{Key: "v", Value: 1}, {Key: "extra", Value: 1}}}}
+		assert.NoError(t, New(WithObjectMode(ObjectStrict)).Test(exp, act))
+	})
+
+	t.Run("strict directive applies to nested documents", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "user", Value: Strict(jwalk.Document{{Key: "address", Value: jwalk.Document{{Key: "city", Value: "Paris"}}}})}}
+		act := jwalk.Document{{Key: "user", Value: jwalk.Document{{Key: "address", Value: jwalk.Document{{Key: "city", Value: "Paris"}, {Key: "zip", Value: "75001"}}}}}}
+		err := New().Test(exp, act)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("user"), KeySegment("address"), KeySegment("zip")}, merr.Path)
+		}
+	})
+
+	t.Run("ordered prefix allows trailing elements", func(t *testing.T) {
+		tester := New(WithArrayMode(ArrayOrderedPrefix))
+		assert.NoError(t, tester.Test(jwalk.Array{1, 2}, jwalk.Array{1, 2, 3}))
+		assert.Error(t, tester.Test(jwalk.Array{2, 1}, jwalk.Array{1, 2, 3}))
+		assert.Error(t, tester.Test(jwalk.Array{1, 2}, jwalk.Array{1}))
+	})
+
+	t.Run("unordered requires permutation", func(t *testing.T) {
+		tester := New(WithArrayMode(ArrayUnordered))
+		assert.NoError(t, tester.Test(jwalk.Array{1, 2, 3}, jwalk.Array{3, 1, 2}))
+		assert.Error(t, tester.Test(jwalk.Array{1, 2}, jwalk.Array{2, 1, 3}))
+		assert.Error(t, tester.Test(jwalk.Array{1, 1}, jwalk.Array{1, 2}))
+	})
+
+	t.Run("unordered subset allows extra elements", func(t *testing.T) {
+		tester := New(WithArrayMode(ArrayUnorderedSubset))
+		exp := jwalk.Array{jwalk.Document{{Key: "id", Value: 2}}}
+		act := jwalk.Array{jwalk.Document{{Key: "id", Value: 1}}, jwalk.Document{{Key: "id", Value: 2}, {Key: "x", Value: 1}}}
+		assert.NoError(t, tester.Test(exp, act))
+	})
+
+	t.Run("unordered failure reports array path", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "tags", Value: jwalk.Array{"a", "z"}}}
+		act := jwalk.Document{{Key: "tags", Value: jwalk.Array{"b", "a"}}}
+		err := New(WithArrayMode(ArrayUnordered)).Test(exp, act)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("tags")}, merr.Path)
+			assert.Equal(t, "z", merr.Expected)
+		}
+	})
+
+	t.Run("mode directive flips arrays locally", func(t *testing.T) {
+		exp := jwalk.Document{
+			{Key: "tags", Value: InMode(0, ArrayUnordered, jwalk.Array{"a", "b"})},
+			{Key: "ids", Value: jwalk.Array{1, 2}},
+		}
+		assert.NoError(t, New().Test(exp, jwalk.Document{{Key: "tags", Value: jwalk.Array{"b", "a"}}, {Key: "ids", Value: jwalk.Array{1, 2}}}))
+		assert.Error(t, New().Test(exp, jwalk.Document{{Key: "tags", Value: jwalk.Array{"b", "a"}}, {Key: "ids", Value: jwalk.Array{2, 1}}}))
+	})
+
+	t.Run("eq stays strict under unordered subset arrays", func(t *testing.T) {
+		tester := New(WithArrayMode(ArrayUnorderedSubset))
+		exp := jwalk.Document{{Key: "v", Value: Eq(Arr(1, 2))}}
+		assert.NoError(t, tester.Test(exp, jwalk.Document{{Key: "v", Value: jwalk.Array{1, 2}}}))
+		assert.Error(t, tester.Test(exp, jwalk.Document{{Key: "v", Value: jwalk.Array{2, 1, 3}}}))
+		assert.Error(t, tester.Test(exp, jwalk.Document{{Key: "v", Value: jwalk.Array{2, 1}}}))
+	})
+
+	t.Run("eq stays strict under local subset objects", func(t *testing.T) {
+		exp := Subset(Obj("user", Eq(Obj("address", Obj("city", "Paris")))))
+		act := jwalk.Document{{Key: "user", Value: jwalk.Document{{Key: "address", Value: jwalk.Document{{Key: "city", Value: "Paris"}, {Key: "zip", Value: "75001"}}}}}}
+		err := New(WithObjectMode(ObjectStrict)).Test(exp, act)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, UnexpectedKey, merr.Kind)
+			assert.Equal(t, Path{KeySegment("user"), KeySegment("address"), KeySegment("zip")}, merr.Path)
+		}
+	})
+}
+
+func TestTester_TestContains(t *testing.T) {
+	events := jwalk.Array{
+		jwalk.Document{{Key: "type", Value: "created"}, {Key: "at", Value: 1}},
+		jwalk.Document{{Key: "type", Value: "viewed"}, {Key: "at", Value: 2}},
+		jwalk.Document{{Key: "type", Value: "paid"}, {Key: "at", Value: 3}},
+	}
+
+	t.Run("subset documents match distinct elements", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "events", Value: Contains(
+			jwalk.Document{{Key: "type", Value: "paid"}},
+			jwalk.Document{{Key: "type", Value: "created"}},
+		)}}
+		assert.NoError(t, New().Test(exp, jwalk.Document{{Key: "events", Value: events}}))
+	})
+
+	t.Run("missing element reports array path and rule", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "events", Value: Contains(jwalk.Document{{Key: "type", Value: "refunded"}})}}
+		err := New().Test(exp, jwalk.Document{{Key: "events", Value: events}})
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("events")}, merr.Path)
+			assert.Equal(t, "$contains", merr.Rule)
+			assert.Equal(t, RuleFailed, merr.Kind)
+		}
+	})
+
+	t.Run("in order with interleaved entries succeeds", func(t *testing.T) {
+		exp := ContainsInOrder(jwalk.Document{{Key: "type", Value: "created"}}, jwalk.Document{{Key: "type", Value: "paid"}})
+		assert.NoError(t, New().Test(exp, events))
+	})
+
+	t.Run("in order rejects reversed entries", func(t *testing.T) {
+		exp := ContainsInOrder(jwalk.Document{{Key: "type", Value: "paid"}}, jwalk.Document{{Key: "type", Value: "created"}})
+		assert.Error(t, New().Test(exp, events))
+	})
+
+	t.Run("plain slice actual succeeds", func(t *testing.T) {
+		assert.NoError(t, New().Test(Contains("b"), []any{"a", "b"}))
+	})
+}
+
+func TestTester_TestElementsMatch(t *testing.T) {
+	t.Run("permutation with overlapping subset documents succeeds", func(t *testing.T) {
+		exp := Unordered(
+			jwalk.Document{{Key: "role", Value: "user"}},
+			jwalk.Document{{Key: "role", Value: "user"}, {Key: "name", Value: "bob"}},
+		)
+		act := jwalk.Array{
+			jwalk.Document{{Key: "role", Value: "user"}, {Key: "name", Value: "bob"}},
+			jwalk.Document{{Key: "role", Value: "user"}, {Key: "name", Value: "amy"}},
+		}
+		assert.NoError(t, New().Test(exp, act))
+	})
+
+	t.Run("failure reports closest candidate mismatches", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "users", Value: Unordered(
+			jwalk.Document{{Key: "id", Value: 1}, {Key: "name", Value: "x"}},
+			jwalk.Document{{Key: "id", Value: 2}, {Key: "name", Value: "y"}},
+		)}}
+		act := jwalk.Document{{Key: "users", Value: jwalk.Array{
+			jwalk.Document{{Key: "id", Value: 2}, {Key: "name", Value: "y"}},
+			jwalk.Document{{Key: "id", Value: 1}, {Key: "name", Value: "z"}},
+		}}}
+		err := New().Test(exp, act)
+		var merr *MismatchError
+		if !assert.ErrorAs(t, err, &merr) {
+			return
+		}
+		assert.Equal(t, Path{KeySegment("users")}, merr.Path)
+		assert.Equal(t, "$elementsMatch", merr.Rule)
+		var nested *MultiError
+		if assert.ErrorAs(t, merr.Err, &nested) && assert.Len(t, nested.Mismatches, 1) {
+			assert.Equal(t, Path{IndexSegment(1), KeySegment("name")}, nested.Mismatches[0].Path)
+			assert.Equal(t, "z", nested.Mismatches[0].Actual)
+		}
+	})
+
+	t.Run("large primitive arrays match by counting", func(t *testing.T) {
+		const n = 20000
+		exp := make([]any, n)
+		act := make(jwalk.Array, n)
+		for i := range n {
+			exp[i] = i
+			act[n-1-i] = float64(i)
+		}
+		assert.NoError(t, New().Test(Unordered(exp...), act))
+		act[0] = "x"
+		assert.Error(t, New().Test(Unordered(exp...), act))
+	})
+
+	t.Run("ignored elements disable counting", func(t *testing.T) {
+		assert.NoError(t, New(WithIgnorePaths("[0]")).Test(Unordered(1, 2), jwalk.Array{9, 2}))
+	})
+
+	t.Run("collect reports every unmatched element", func(t *testing.T) {
+		err := New(WithCollectAll()).Test(Unordered(1, 2, 3), jwalk.Array{1, 5, 6})
+		var multi *MultiError
+		if assert.ErrorAs(t, err, &multi) {
+			assert.Len(t, multi.Mismatches, 2)
+		}
+	})
+}
+
+func TestTester_TestKeyedBy(t *testing.T) {
+	user := func(id any, email string) jwalk.Document {
+		return jwalk.Document{{Key: "id", Value: id}, {Key: "email", Value: email}}
+	}
+	asMismatches := func(t *testing.T, err error) []*MismatchError {
+		t.Helper()
+		if !assert.Error(t, err) {
+			t.FailNow()
+		}
+		return mismatchesOf(err)
+	}
+
+	t.Run("items match in any order succeeds", func(t *testing.T) {
+		exp := KeyedBy("id", user(1, "a@x"), user(2, "b@x"))
+		assert.NoError(t, New().Test(exp, jwalk.Array{user(2, "b@x"), user(1, "a@x")}))
+	})
+
+	t.Run("numeric keys match across types succeeds", func(t *testing.T) {
+		exp := KeyedBy("id", jwalk.Document{{Key: "id", Value: float64(42)}})
+		assert.NoError(t, New().Test(exp, []map[string]any{{"id": 42, "extra": true}}))
+	})
+
+	t.Run("value mismatch reports keyed path", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "users", Value: KeyedBy("id", user(1, "a@x"), user(42, "z@x"))}}
+		act := jwalk.Document{{Key: "users", Value: jwalk.Array{user(42, "q@x"), user(1, "a@x")}}}
+		ms := asMismatches(t, New().Test(exp, act))
+		assert.Equal(t, ".users[id=42].email", ms[0].Path.String())
+		assert.Equal(t, "/users/0/email", ms[0].Path.JSONPointer())
+		assert.Equal(t, ValueMismatch, ms[0].Kind)
+	})
+
+	t.Run("missing and unexpected entities are reported", func(t *testing.T) {
+		exp := KeyedBy("id", user(1, "a@x"), user(2, "b@x"))
+		ms := asMismatches(t, New(WithCollectAll()).Test(exp, jwalk.Array{user(1, "a@x"), user(3, "c@x")}))
+		if assert.Len(t, ms, 2) {
+			assert.Equal(t, KeyNotFound, ms[0].Kind)
+			assert.Equal(t, "[id=2]", ms[0].Path.String())
+			assert.Equal(t, UnexpectedKey, ms[1].Kind)
+			assert.Equal(t, "[id=3]", ms[1].Path.String())
+			assert.Equal(t, "$keyedBy", ms[1].Rule)
+		}
+	})
+
+	t.Run("allow extra ignores unexpected entities", func(t *testing.T) {
+		exp := KeyedBy("id", user(1, "a@x")).AllowExtra()
+		assert.NoError(t, New().Test(exp, jwalk.Array{user(3, "c@x"), user(1, "a@x")}))
+	})
+
+	t.Run("composite key pairs by every field", func(t *testing.T) {
+		item := func(tenant string, id int, v int) jwalk.Document {
+			return jwalk.Document{{Key: "tenant", Value: tenant}, {Key: "id", Value: id}, {Key: "v", Value: v}}
+		}
+		exp := KeyedByFields([]string{"tenant", "id"}, item("a", 1, 10), item("b", 1, 20))
+		assert.NoError(t, New().Test(exp, jwalk.Array{item("b", 1, 20), item("a", 1, 10)}))
+		ms := asMismatches(t, New().Test(exp, jwalk.Array{item("b", 1, 21), item("a", 1, 10)}))
+		assert.Equal(t, `[tenant="b",id=1].v`, ms[0].Path.String())
+	})
+
+	t.Run("duplicate actual key returns error", func(t *testing.T) {
+		exp := KeyedBy("id", user(1, "a@x")).AllowExtra()
+		ms := asMismatches(t, New().Test(exp, jwalk.Array{user(1, "a@x"), user(1, "b@x")}))
+		assert.Contains(t, ms[0].Message, "duplicate key")
+	})
+
+	t.Run("invalid items return error", func(t *testing.T) {
+		assert.Error(t, New().Test(KeyedBy("id", jwalk.Document{{Key: "email", Value: "a"}}), jwalk.Array{}))
+		assert.Error(t, New().Test(KeyedBy("id", jwalk.Document{{Key: "id", Value: &Any{}}}), jwalk.Array{}))
+		assert.Error(t, New().Test(KeyedBy("id", user(1, "a"), user(1, "b")), jwalk.Array{}))
+	})
+
+	t.Run("ignored paths apply below keyed segments", func(t *testing.T) {
+		exp := KeyedBy("id", user(1, "a@x"))
+		assert.NoError(t, New(WithIgnorePaths("[*].email")).Test(exp, jwalk.Array{user(1, "other@x")}))
+	})
+
+	t.Run("non-array actual returns error", func(t *testing.T) {
+		ms := asMismatches(t, New().Test(KeyedBy("id"), "x"))
+		assert.Equal(t, TypeMismatch, ms[0].Kind)
+	})
+}
+
+func TestTester_TestEach(t *testing.T) {
+	item := func(status string, price float64) jwalk.Document {
+		return jwalk.Document{{Key: "status", Value: status}, {Key: "price", Value: price}}
+	}
+	items := jwalk.Array{item("active", 5), item("active", 0), item("inactive", 3)}
+
+	t.Run("each reports failing element paths", func(t *testing.T) {
+		exp := jwalk.Document{{Key: "items", Value: Each(Obj("status", "active", "price", Gt(0)))}}
+		err := New(WithCollectAll()).Test(exp, jwalk.Document{{Key: "items", Value: items}})
+		var multi *MultiError
+		if assert.ErrorAs(t, err, &multi) && assert.Len(t, multi.Mismatches, 2) {
+			assert.Equal(t, Path{KeySegment("items"), IndexSegment(1), KeySegment("price")}, multi.Mismatches[0].Path)
+			assert.Equal(t, Path{KeySegment("items"), IndexSegment(2), KeySegment("status")}, multi.Mismatches[1].Path)
+		}
+	})
+
+	t.Run("some and count succeed", func(t *testing.T) {
+		assert.NoError(t, New().Test(Some(Obj("status", "inactive")), items))
+		assert.NoError(t, New().Test(Count(Obj("status", "active"), 2), items))
+		assert.NoError(t, New().Test(Count(Obj("price", Gt(0)), Gte(2)), items))
+	})
+
+	t.Run("count mismatch returns error", func(t *testing.T) {
+		assert.Error(t, New().Test(Count(Obj("status", "active"), Gte(3)), items))
+	})
+
+	t.Run("struct slice actual succeeds", func(t *testing.T) {
+		type product struct {
+			Price float64 `json:"price"`
+		}
+		assert.NoError(t, New().Test(Each(Obj("price", Gt(0))), []product{{1}, {2}}))
+	})
+}
+
+func TestTester_TestClock(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tester := New(WithClock(func() time.Time { return now }))
+	actual := jwalk.Document{
+		{Key: "createdAt", Value: "2024-05-01T13:59:58+02:00"},
+		{Key: "events", Value: jwalk.Array{jwalk.Document{{Key: "at", Value: now.Add(-time.Minute)}}}},
+	}
+
+	t.Run("within now uses clock succeeds", func(t *testing.T) {
+		assert.NoError(t, tester.Test(Obj("createdAt", WithinNow(5*time.Second)), actual))
+	})
+
+	t.Run("nested rules use clock succeeds", func(t *testing.T) {
+		assert.NoError(t, tester.Test(Obj("events", Each(Obj("at", BeforeNow()))), actual))
+	})
+
+	t.Run("later clock returns error", func(t *testing.T) {
+		later := New(WithClock(func() time.Time { return now.Add(time.Minute) }))
+		err := later.Test(Obj("createdAt", WithinNow(5*time.Second)), actual)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("createdAt")}, merr.Path)
+			assert.Equal(t, "$within", merr.Rule)
+		}
+	})
+}
+
+func TestTester_TestFloatTolerance(t *testing.T) {
+	tester := New(WithFloatTolerance(1e-9, 0))
+	a, b := 0.1, 0.2
+
+	t.Run("close numeric leaves succeed", func(t *testing.T) {
+		exp := Obj("total", 0.3, "items", Arr(0.1, 0.2))
+		assert.NoError(t, tester.Test(exp, jwalk.Document{
+			{Key: "total", Value: a + b},
+			{Key: "items", Value: jwalk.Array{0.1 + 1e-12, 0.2}},
+		}))
+	})
+
+	t.Run("default tester compares exactly", func(t *testing.T) {
+		assert.Error(t, New().Test(0.3, a+b))
+	})
+
+	t.Run("unordered arrays use tolerance", func(t *testing.T) {
+		assert.NoError(t, New(WithFloatTolerance(0.01, 0), WithArrayMode(ArrayUnordered)).Test(Arr(1.0, 2.0), jwalk.Array{2.001, 0.999}))
+	})
+
+	t.Run("ne within tolerance returns error", func(t *testing.T) {
+		assert.Error(t, tester.Test(Obj("total", Ne(0.3)), jwalk.Document{{Key: "total", Value: a + b}}))
+		assert.NoError(t, tester.Test(Obj("total", Ne(0.3)), jwalk.Document{{Key: "total", Value: 0.5}}))
+	})
+
+	t.Run("mismatch reports delta", func(t *testing.T) {
+		err := tester.Test(Obj("total", 0.3), jwalk.Document{{Key: "total", Value: 0.5}})
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, ValueMismatch, merr.Kind)
+			assert.Equal(t, Path{KeySegment("total")}, merr.Path)
+			assert.Contains(t, merr.Message, "delta 0.2")
+		}
+	})
+
+	t.Run("non-numeric leaves are unaffected", func(t *testing.T) {
+		var merr *MismatchError
+		if assert.ErrorAs(t, tester.Test("1", 1), &merr) {
+			assert.Equal(t, TypeMismatch, merr.Kind)
+		}
+	})
+}
+
+func TestTester_TestParseJSON(t *testing.T) {
+	reg, err := NewRegistry()
+	require.NoError(t, err)
+	var exp any
+	err = json.UnmarshalRead(strings.NewReader(`{"body": {"$parseJSON": {"event": "created", "data": {"id": {"$gt": 0}, "tags": ["a"]}}}}`), &exp, json.WithUnmarshalers(jwalk.Unmarshalers(reg)))
+	require.NoError(t, err)
+
+	t.Run("embedded json subset succeeds", func(t *testing.T) {
+		actual := map[string]any{"body": `{"event":"created","extra":true,"data":{"id":7,"tags":["a"]}}`}
+		assert.NoError(t, New().Test(exp, actual))
+	})
+
+	t.Run("embedded json decodes with the expectation's registry", func(t *testing.T) {
+		var exp any
+		require.NoError(t, reg.Unmarshal([]byte(`{"$parseJSON": {"id": {"$gt": 0}}}`), &exp))
+		assert.NoError(t, New().Test(exp, `{"id":1,"$schema":"x"}`))
+		// A leading "$" key is a directive to the registry, as in a top-level
+		// actual decoded with it.
+		err := New().Test(exp, `{"meta":{"$schema":"x"}}`)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, "$parseJSON", merr.Rule)
+			assert.Contains(t, merr.Message, `directive "schema" not registered`)
+		}
+		// Rules built in Go have no registry and decode plain JSON.
+		assert.NoError(t, New().Test(ParseJSONOf(Obj("meta", Obj("$schema", "x"))), `{"meta":{"$schema":"x"}}`))
+	})
+
+	t.Run("mismatch path continues inside decoded value", func(t *testing.T) {
+		actual := map[string]any{"body": `{"event":"created","data":{"id":0,"tags":["b"]}}`}
+		err := New(WithCollectAll()).Test(exp, actual)
+		var multi *MultiError
+		if assert.ErrorAs(t, err, &multi) && assert.Len(t, multi.Mismatches, 2) {
+			assert.Equal(t, Path{KeySegment("body"), KeySegment("data"), KeySegment("id")}, multi.Mismatches[0].Path)
+			assert.Equal(t, Path{KeySegment("body"), KeySegment("data"), KeySegment("tags"), IndexSegment(0)}, multi.Mismatches[1].Path)
+		}
+	})
+}
+
+func TestTester_TestDecodingDirectives(t *testing.T) {
+	reg, err := NewRegistry()
+	require.NoError(t, err)
+	var exp any
+	err = json.UnmarshalRead(strings.NewReader(`{
+		"redirect": {"$url": {"host": "example.com", "query": {"state": "abc"}}},
+		"token": {"$jwt": {"claims": {"sub": {"$regex": "^[0-9]+$"}}}},
+		"blob": {"$base64": {"value": {"ok": true}, "as": "json"}}
+	}`), &exp, json.WithUnmarshalers(jwalk.Unmarshalers(reg)))
+	require.NoError(t, err)
+	actual := func(redirect string) map[string]any {
+		return map[string]any{
+			"redirect": redirect,
+			"token":    "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJzdWIiOiI0MiIsImFkbWluIjp0cnVlfQ.c2ln",
+			"blob":     "eyJvayI6dHJ1ZSwibiI6MX0=",
+		}
+	}
+
+	t.Run("decoded parts subset succeeds", func(t *testing.T) {
+		assert.NoError(t, New().Test(exp, actual("https://example.com/cb?state=abc&code=xyz")))
+	})
+
+	t.Run("query mismatch reports path", func(t *testing.T) {
+		err := New().Test(exp, actual("https://example.com/cb?state=def"))
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("redirect"), KeySegment("query"), KeySegment("state")}, merr.Path)
+		}
+	})
+
+	t.Run("decoded json uses the expectation's registry", func(t *testing.T) {
+		// {"meta":{"$schema":"x"}} as a blob and as the claims of a token.
+		blob := "eyJtZXRhIjp7IiRzY2hlbWEiOiJ4In19"
+		token := "eyJhbGciOiJub25lIn0.eyJtZXRhIjp7IiRzY2hlbWEiOiJ4In19.c2ln"
+		var exp any
+		require.NoError(t, reg.Unmarshal([]byte(`{"blob": {"$base64": {"value": {}, "as": "json"}}, "token": {"$jwt": {}}}`), &exp))
+		err := New(WithCollectAll()).Test(exp, map[string]any{"blob": blob, "token": token})
+		var multi *MultiError
+		if assert.ErrorAs(t, err, &multi) && assert.Len(t, multi.Mismatches, 2) {
+			assert.Contains(t, multi.Mismatches[0].Message, `directive "schema" not registered`)
+			assert.Contains(t, multi.Mismatches[1].Message, `directive "schema" not registered`)
+		}
+		assert.NoError(t, New().Test(Base64Of(Obj("meta", Obj("$schema", "x"))).AsJSON(), blob))
+		assert.NoError(t, New().Test(JWTOf(Obj("claims", Obj("meta", Obj("$schema", "x")))), token))
+	})
+
+	t.Run("go rules decode plain json", func(t *testing.T) {
+		// {"n":{"$test.eq":1}} and a token with claims {"sub":{"$test.gt":0}}.
+		blob := "eyJuIjp7IiR0ZXN0LmVxIjoxfX0="
+		token := "eyJhbGciOiJub25lIn0.eyJzdWIiOnsiJHRlc3QuZ3QiOjB9fQ.c2ln"
+		assert.Error(t, New().Test(Base64Of(Obj("n", 1)).AsJSON(), blob))
+		assert.NoError(t, New().Test(Base64Of(Obj("n", Obj("$test.eq", 1))).AsJSON(), blob))
+		assert.Error(t, New().Test(JWTOf(Obj("claims", Obj("sub", 5))), token))
+		assert.NoError(t, New().Test(JWTOf(Obj("claims", Obj("sub", Obj("$test.gt", 0)))), token))
+	})
+}
+
+func TestTester_TestCaptures(t *testing.T) {
+	response := jwalk.Document{
+		{Key: "id", Value: "o-1"},
+		{Key: "customer", Value: jwalk.Document{{Key: "id", Value: 7.0}, {Key: "tags", Value: jwalk.Array{"a"}}}},
+		{Key: "order", Value: jwalk.Document{{Key: "customerId", Value: 7}}},
+		{Key: "items", Value: jwalk.Array{
+			jwalk.Document{{Key: "orderId", Value: "o-1"}},
+			jwalk.Document{{Key: "orderId", Value: "o-2"}},
+		}},
+	}
+
+	t.Run("ref matches captured value succeeds", func(t *testing.T) {
+		exp := Obj("customer", Obj("id", Capture("cid")), "order", Obj("customerId", Ref("cid")))
+		assert.NoError(t, New().Test(exp, response))
+	})
+
+	t.Run("ref mismatch reports element path", func(t *testing.T) {
+		exp := Obj("id", Capture("orderId"), "items", Each(Obj("orderId", Ref("orderId"))))
+		err := New().Test(exp, response)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("items"), IndexSegment(1), KeySegment("orderId")}, merr.Path)
+		}
+	})
+
+	t.Run("ref compares containers exactly", func(t *testing.T) {
+		exp := Obj("customer", Capture("c"), "other", Ref("c"))
+		other := map[string]any{"id": 7, "tags": []any{"a"}, "extra": true}
+		assert.Error(t, New().Test(exp, append(slices.Clone(response), jwalk.Entry{Key: "other", Value: other})))
+		delete(other, "extra")
+		assert.NoError(t, New().Test(exp, append(slices.Clone(response), jwalk.Entry{Key: "other", Value: other})))
+	})
+
+	t.Run("unbound ref returns error", func(t *testing.T) {
+		err := New().Test(Obj("id", Ref("nope")), response)
+		assert.ErrorIs(t, err, ErrUnboundRef)
+	})
+
+	t.Run("conflicting capture returns error", func(t *testing.T) {
+		err := New().Test(Obj("items", Each(Obj("orderId", Capture("orderId")))), response)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, "$capture", merr.Rule)
+			assert.Equal(t, Path{KeySegment("items"), IndexSegment(1), KeySegment("orderId")}, merr.Path)
+		}
+	})
+
+	t.Run("contains in order retries an earlier capture", func(t *testing.T) {
+		exp := ContainsInOrder(Obj("a", Capture("x")), Obj("b", Ref("x")))
+		actual := jwalk.Array{Obj("a", 1), Obj("a", 2), Obj("b", 2)}
+		caps, err := New().TestCaptures(exp, actual)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"x": 2}, caps)
+		_, err = New().TestCaptures(exp, jwalk.Array{Obj("a", 1), Obj("a", 2), Obj("b", 3)})
+		assert.Error(t, err)
+	})
+
+	t.Run("test captures returns the bindings of the call", func(t *testing.T) {
+		tester := New()
+		caps, err := tester.TestCaptures(Obj("id", Capture("orderId")), response)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]any{"orderId": "o-1"}, caps)
+		caps, err = tester.TestCaptures(Obj("id", Capture("other"), "missing", 1), response)
+		assert.Error(t, err)
+		assert.Nil(t, caps)
+	})
+
+	t.Run("captures are scoped to one call by default", func(t *testing.T) {
+		tester := New()
+		require.NoError(t, tester.Test(Obj("id", Capture("orderId")), response))
+		assert.Empty(t, tester.Captures())
+		assert.ErrorIs(t, tester.Test(Ref("orderId"), "o-1"), ErrUnboundRef)
+	})
+
+	t.Run("persistent captures carry over to later calls", func(t *testing.T) {
+		tester := New(WithPersistentCaptures())
+		require.NoError(t, tester.Test(Obj("id", Capture("orderId")), response))
+		assert.Equal(t, map[string]any{"orderId": "o-1"}, tester.Captures())
+		assert.NoError(t, tester.Test(Obj("orderId", Ref("orderId")), map[string]any{"orderId": "o-1"}))
+		require.NoError(t, tester.Test(Obj("id", Capture("orderId")), map[string]any{"id": "o-9"}))
+		assert.Equal(t, map[string]any{"orderId": "o-9"}, tester.Captures())
+		tester.ResetCaptures()
+		assert.Empty(t, tester.Captures())
+		assert.ErrorIs(t, tester.Test(Ref("orderId"), "o-9"), ErrUnboundRef)
+	})
+
+	t.Run("concurrent calls do not share captures", func(t *testing.T) {
+		tester := New()
+		var wg sync.WaitGroup
+		for i := range 8 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				v := fmt.Sprint(i)
+				caps, err := tester.TestCaptures(Obj("a", Capture("x"), "b", Ref("x")), map[string]any{"a": v, "b": v})
+				assert.NoError(t, err)
+				assert.Equal(t, map[string]any{"x": v}, caps)
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("failing call keeps no persistent captures", func(t *testing.T) {
+		tester := New(WithPersistentCaptures())
+		require.Error(t, tester.Test(Obj("id", Capture("orderId"), "missing", 1), response))
+		assert.Empty(t, tester.Captures())
+	})
+
+	withLast := func(v string) jwalk.Document {
+		return append(slices.Clone(response), jwalk.Entry{Key: "last", Value: v})
+	}
+
+	t.Run("or keeps only the matching alternative's captures", func(t *testing.T) {
+		failed := Obj("id", AnyOf(AllOf(Capture("x"), "nope"), Anything()), "last", Ref("x"))
+		assert.ErrorIs(t, New().Test(failed, withLast("o-1")), ErrUnboundRef)
+		matched := Obj("id", AnyOf("nope", Capture("x")), "last", Ref("x"))
+		assert.NoError(t, New().Test(matched, withLast("o-1")))
+	})
+
+	t.Run("not and nor discard captures", func(t *testing.T) {
+		assert.ErrorIs(t, New().Test(Obj("id", Negate(AllOf(Capture("x"), "nope")), "last", Ref("x")), withLast("o-1")), ErrUnboundRef)
+		assert.ErrorIs(t, New().Test(Obj("id", NoneOf(AllOf(Capture("x"), "nope")), "last", Ref("x")), withLast("o-1")), ErrUnboundRef)
+	})
+
+	t.Run("unordered matching keeps captures of the chosen pairing", func(t *testing.T) {
+		// The first expected element matches either item, but only the
+		// pairing that leaves "o-1" to the second one succeeds.
+		items := []any{Obj("orderId", Capture("x")), Obj("orderId", "o-1")}
+		for name, exp := range map[string]any{
+			"elementsMatch": Unordered(items...),
+			"contains":      Contains(items...),
+		} {
+			t.Run(name, func(t *testing.T) {
+				assert.NoError(t, New().Test(Obj("items", exp, "last", Ref("x")), withLast("o-2")))
+				assert.Error(t, New().Test(Obj("items", exp, "last", Ref("x")), withLast("o-1")))
+			})
+		}
+		t.Run("array mode", func(t *testing.T) {
+			tester := New(WithArrayMode(ArrayUnordered))
+			assert.NoError(t, tester.Test(Obj("items", Arr(items...), "last", Ref("x")), withLast("o-2")))
+		})
+	})
+
+	t.Run("some keeps captures of the first matching element", func(t *testing.T) {
+		exp := Obj("items", Some(Obj("orderId", Capture("x"))), "last", Ref("x"))
+		assert.NoError(t, New().Test(exp, withLast("o-1")))
+	})
+
+	t.Run("count keeps captures of matching elements", func(t *testing.T) {
+		exp := Obj("items", Count(Obj("orderId", AllOf(In("o-2"), Capture("x"))), 1), "last", Ref("x"))
+		assert.NoError(t, New().Test(exp, withLast("o-2")))
+		// The second item conflicts with the capture kept from the first.
+		err := New().Test(Obj("items", Count(Obj("orderId", Capture("x")), 2)), response)
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, "$count", merr.Rule)
+			assert.Contains(t, merr.Message, "1 elements matched")
+		}
+	})
+}
+
+func TestTester_TestDefinitions(t *testing.T) {
+	decode := func(t *testing.T, s string) any {
+		t.Helper()
+		reg, err := NewRegistry()
+		require.NoError(t, err)
+		var exp any
+		require.NoError(t, json.UnmarshalRead(strings.NewReader(s), &exp, json.WithUnmarshalers(jwalk.Unmarshalers(reg))))
+		return exp
+	}
+	money := func(amount float64) map[string]any {
+		return map[string]any{"amount": amount, "currency": "EUR"}
+	}
+
+	t.Run("defs section with use succeeds", func(t *testing.T) {
+		exp := decode(t, `{
+			"$defs": {"money": {"amount": {"$gte": 0}, "currency": {"$regex": "^[A-Z]{3}$"}}},
+			"price": {"$use": "money"},
+			"total": {"$use": "money"}
+		}`)
+		assert.NoError(t, New().Test(exp, map[string]any{"price": money(1), "total": money(2)}))
+	})
+
+	t.Run("use mismatch reports path inside definition", func(t *testing.T) {
+		exp := decode(t, `{"$defs": {"money": {"amount": {"$gte": 0}}}, "total": {"$use": "money"}}`)
+		err := New().Test(exp, map[string]any{"total": money(-1)})
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("total"), KeySegment("amount")}, merr.Path)
+		}
+	})
+
+	t.Run("recursive definition succeeds", func(t *testing.T) {
+		exp := decode(t, `{
+			"$defs": {"node": {"name": {"$type": "string"}, "children": {"$each": {"$use": "node"}}}},
+			"root": {"$use": "node"}
+		}`)
+		leaf := func(name string) map[string]any { return map[string]any{"name": name, "children": []any{}} }
+		tree := map[string]any{"name": "a", "children": []any{leaf("b"), map[string]any{"name": "c", "children": []any{leaf("d")}}}}
+		assert.NoError(t, New().Test(exp, map[string]any{"root": tree}))
+
+		tree["children"].([]any)[1].(map[string]any)["children"] = []any{map[string]any{"name": 1, "children": []any{}}}
+		err := New().Test(exp, map[string]any{"root": tree})
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, Path{KeySegment("root"), KeySegment("children"), IndexSegment(1), KeySegment("children"), IndexSegment(0), KeySegment("name")}, merr.Path)
+		}
+	})
+
+	t.Run("self reference without descending returns error", func(t *testing.T) {
+		exp := decode(t, `{"$defs": {"a": {"$use": "b"}, "b": {"$use": "a"}}, "x": {"$use": "a"}}`)
+		err := New().Test(exp, map[string]any{"x": 1})
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, "$use", merr.Rule)
+			assert.Contains(t, merr.Message, "refers to itself")
+		}
+	})
+
+	t.Run("defs section is not an expected key under strict objects", func(t *testing.T) {
+		exp := decode(t, `{"$defs": {"money": {"amount": {"$gte": 0}}}, "price": {"$use": "money"}, "id": 1}`)
+		tester := New(WithObjectMode(ObjectStrict))
+		assert.NoError(t, tester.Test(exp, map[string]any{"price": map[string]any{"amount": 1}, "id": 1}))
+	})
+
+	t.Run("literal defs key is compared as data", func(t *testing.T) {
+		schema := map[string]any{"$defs": map[string]any{"a": 2}, "type": "object"}
+		exp := Obj("$defs", Obj("a", 1), "type", "object")
+		assert.Error(t, New().Test(exp, schema))
+		assert.Error(t, New().Test(exp, map[string]any{"type": "object"}))
+		assert.NoError(t, New().Test(Obj("$defs", Obj("a", 2), "type", "object"), schema))
+		// In JSON, "$defs" after the first member is data too.
+		assert.Error(t, New().Test(decode(t, `{"type": "object", "$defs": {"a": 1}}`), schema))
+		assert.NoError(t, New().Test(decode(t, `{"type": "object", "$defs": {"a": 2}}`), schema))
+	})
+
+	t.Run("defs after other members is an ordinary key", func(t *testing.T) {
+		exp := decode(t, `{"a": {"b": 1, "$defs": {}}}`)
+		err := New().Test(exp, map[string]any{"a": map[string]any{"b": 1}})
+		var merr *MismatchError
+		if assert.ErrorAs(t, err, &merr) {
+			assert.Equal(t, KeyNotFound, merr.Kind)
+			assert.Equal(t, Path{KeySegment("a"), KeySegment("$defs")}, merr.Path)
+		}
+	})
+
+	t.Run("unknown definition returns error", func(t *testing.T) {
+		assert.ErrorIs(t, New().Test(Obj("x", Use("nope")), map[string]any{"x": 1}), ErrUnknownDefinition)
+	})
+
+	t.Run("tester definitions succeed", func(t *testing.T) {
+		tester := New(WithDefinition("money", Obj("currency", "EUR")))
+		assert.NoError(t, tester.Test(decode(t, `{"price": {"$use": "money"}}`), map[string]any{"price": money(1)}))
+		assert.Error(t, tester.Test(Obj("price", Use("money")), map[string]any{"price": map[string]any{"currency": "USD"}}))
+	})
+
+	t.Run("defs section overrides tester definitions", func(t *testing.T) {
+		tester := New(WithDefinition("money", Obj("currency", "USD")))
+		exp := Defs(map[string]any{"money": Obj("currency", "EUR")}, Obj("price", Use("money")))
+		assert.NoError(t, tester.Test(exp, map[string]any{"price": money(1)}))
+	})
+
+	t.Run("use of optional definition on missing key succeeds", func(t *testing.T) {
+		tester := New(WithDefinition("maybe", IfPresent(1)))
+		assert.NoError(t, tester.Test(Obj("x", Use("maybe")), map[string]any{}))
+	})
+}